@@ -29,7 +29,7 @@ if [ -z "${VERSION:-}" ]; then
     exit 1
 fi
 
-export CGO_ENABLED=0
+export CGO_ENABLED="${CGO_ENABLED:-0}"
 export GOARCH="${ARCH}"
 export GOOS="${OS}"
 export GO111MODULE=on
@@ -37,6 +37,7 @@ export GOFLAGS="-mod=vendor"
 
 go install \
     -installsuffix "static" \
+    -tags "${BUILD_TAGS:-}" \
     -ldflags "                                          \
       -X main.Version=${VERSION}                        \
       -X main.VersionStrategy=${version_strategy:-}     \
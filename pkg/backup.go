@@ -22,13 +22,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	stash "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	api_util "stash.appscode.dev/apimachinery/pkg/util"
-	"stash.appscode.dev/vault/pkg/store"
 
+	"github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 	license "go.bytebuilders.dev/license-verifier/kubernetes"
 	"gomodules.xyz/flags"
@@ -54,6 +56,7 @@ func NewCmdBackup() *cobra.Command {
 			backupOptions: restic.BackupOptions{
 				Host: restic.DefaultHost,
 			},
+			maxConcurrency: 1,
 		}
 	)
 
@@ -62,7 +65,14 @@ func NewCmdBackup() *cobra.Command {
 		Short:             "Takes a backup of Vault",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			flags.EnsureRequiredFlags(cmd, "appbinding", "provider", "storage-secret-name", "storage-secret-namespace")
+			if opt.standalone {
+				return opt.runStandaloneBackup()
+			}
+
+			flags.EnsureRequiredFlags(cmd, "provider", "storage-secret-name", "storage-secret-namespace")
+			if len(opt.appBindingNames) == 0 && opt.appBindingSelector == "" {
+				return fmt.Errorf("either --appbinding or --appbinding-selector must be specified")
+			}
 
 			// prepare client
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
@@ -86,27 +96,14 @@ func NewCmdBackup() *cobra.Command {
 				return err
 			}
 
-			targetRef := api_v1beta1.TargetRef{
-				APIVersion: appcatalog.SchemeGroupVersion.String(),
-				Kind:       appcatalog.ResourceKindApp,
-				Name:       opt.appBindingName,
-				Namespace:  opt.appBindingNamespace,
+			var backupOutput *BackupOutput
+			appBindings, targetRef, err := opt.resolveBackupTarget()
+			if err == nil {
+				backupOutput, err = opt.backupVault(targetRef, appBindings)
 			}
-			var backupOutput *restic.BackupOutput
-			backupOutput, err = opt.backupVault(targetRef)
-			if err != nil {
-				backupOutput = &restic.BackupOutput{
-					BackupTargetStatus: api_v1beta1.BackupTargetStatus{
-						Ref: targetRef,
-						Stats: []api_v1beta1.HostBackupStats{
-							{
-								Hostname: opt.backupOptions.Host,
-								Phase:    api_v1beta1.HostBackupFailed,
-								Error:    err.Error(),
-							},
-						},
-					},
-				}
+			// a failed upload is reported with the stats of every host
+			if err != nil && backupOutput == nil {
+				backupOutput = opt.failedBackupOutput(targetRef, err)
 			}
 			// If output directory specified, then write the output in "output.json" file in the specified directory
 			if opt.outputDir != "" {
@@ -124,8 +121,10 @@ func NewCmdBackup() *cobra.Command {
 	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
 	cmd.Flags().StringVar(&opt.namespace, "namespace", "default", "Namespace of Backup/Restore Session")
 	cmd.Flags().StringVar(&opt.backupSessionName, "backupsession", opt.backupSessionName, "Name of the Backup Session")
-	cmd.Flags().StringVar(&opt.appBindingName, "appbinding", opt.appBindingName, "Name of the app binding")
+	cmd.Flags().StringSliceVar(&opt.appBindingNames, "appbinding", opt.appBindingNames, "Name of the app bindings to backup")
+	cmd.Flags().StringVar(&opt.appBindingSelector, "appbinding-selector", opt.appBindingSelector, "Label selector of the app bindings to backup")
 	cmd.Flags().StringVar(&opt.appBindingNamespace, "appbinding-namespace", opt.appBindingNamespace, "Namespace of the app binding")
+	cmd.Flags().IntVar(&opt.maxConcurrency, "max-concurrency", opt.maxConcurrency, "Maximum number of VaultServers to backup concurrently")
 	cmd.Flags().StringVar(&opt.setupOptions.Provider, "provider", opt.setupOptions.Provider, "Backend provider (i.e. gcs, s3, azure etc)")
 	cmd.Flags().StringVar(&opt.setupOptions.Bucket, "bucket", opt.setupOptions.Bucket, "Name of the cloud bucket/container (keep empty for local backend)")
 	cmd.Flags().StringVar(&opt.setupOptions.Endpoint, "endpoint", opt.setupOptions.Endpoint, "Endpoint for s3/s3 compatible backend or REST backend URL")
@@ -152,11 +151,16 @@ func NewCmdBackup() *cobra.Command {
 	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
 	cmd.Flags().StringVar(&opt.interimDataDir, "interim-data-dir", opt.interimDataDir, "Directory where the targeted data will be stored temporarily before uploading to the backend")
 	cmd.Flags().StringVar(&opt.keyPrefix, "key-prefix", opt.keyPrefix, "prefix that will be append to root-token & unseal-keys")
+	cmd.Flags().BoolVar(&opt.detectKeyPrefix, "detect-key-prefix", opt.detectKeyPrefix, "Specify whether to use the prefix of the only root-token in the store if the root-token of --key-prefix is not found")
+	cmd.Flags().BoolVar(&opt.forceBackup, "force-backup", opt.forceBackup, "Specify whether to take backup even if the Raft index is unchanged since the last backup")
+	cmd.Flags().BoolVar(&opt.unpackSnapshot, "unpack-snapshot", opt.unpackSnapshot, "Specify whether to store the snapshot entries uncompressed so that restic can deduplicate them across backups")
+	opt.addSecondaryStoreFlags(cmd)
+	opt.addStandaloneFlags(cmd)
 
 	return cmd
 }
 
-func (opt *vaultOptions) backupVault(targetRef api_v1beta1.TargetRef) (*restic.BackupOutput, error) {
+func (opt *vaultOptions) backupVault(targetRef api_v1beta1.TargetRef, appBindings []appcatalog.AppBinding) (*BackupOutput, error) {
 	var err error
 	err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
 	if err != nil {
@@ -195,71 +199,452 @@ func (opt *vaultOptions) backupVault(targetRef api_v1beta1.TargetRef) (*restic.B
 		return nil, err
 	}
 
-	appBinding, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).Get(context.TODO(), opt.appBindingName, metav1.GetOptions{})
+	// keep the host & interim directory of single VaultServer backup unchanged, so that the existing restore process works as it is
+	multiple := len(appBindings) > 1
+
+	if err = clearDir(opt.interimDataDir); err != nil {
+		return nil, err
+	}
+
+	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
 	if err != nil {
 		return nil, err
 	}
 
+	maxConcurrency := opt.maxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	// take the snapshots of the VaultServers concurrently, at most maxConcurrency at a time
+	results := make([]vaultBackupResult, len(appBindings))
+	wg := sync.WaitGroup{}
+	concurrencyLimiter := make(chan bool, maxConcurrency)
+	defer close(concurrencyLimiter)
+
+	for i := range appBindings {
+		concurrencyLimiter <- true
+		wg.Add(1)
+
+		go func(idx int, appBinding *appcatalog.AppBinding) {
+			defer func() {
+				<-concurrencyLimiter
+				wg.Done()
+			}()
+
+			o := opt.forAppBinding(appBinding, multiple)
+			// sh field in ResticWrapper is a pointer, so use a copy in each go routine
+			results[idx] = o.prepareVaultBackup(resticWrapper.Copy(), appBinding)
+		}(i, &appBindings[i])
+	}
+	wg.Wait()
+
+	return opt.uploadVaultBackups(resticWrapper, targetRef, results, maxConcurrency)
+}
+
+// uploadVaultBackups uploads the prepared backup sets, one restic host per VaultServer.
+// The errors are reported in the stats of the respective host. A failed upload is returned along with the output.
+func (opt *vaultOptions) uploadVaultBackups(resticWrapper *restic.ResticWrapper, targetRef api_v1beta1.TargetRef, results []vaultBackupResult, maxConcurrency int) (*BackupOutput, error) {
+	backupOutput := &BackupOutput{
+		BackupOutput: restic.BackupOutput{
+			BackupTargetStatus: api_v1beta1.BackupTargetStatus{
+				Ref: targetRef,
+			},
+		},
+	}
+
+	var backupOptions []restic.BackupOptions
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			klog.Errorf("failed to backup VaultServer of host %s. Reason: %v", result.backupOptions.Host, result.err)
+			backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, api_v1beta1.HostBackupStats{
+				Hostname: result.backupOptions.Host,
+				Phase:    api_v1beta1.HostBackupFailed,
+				Error:    result.err.Error(),
+			})
+			continue
+		case result.skipped:
+			backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, api_v1beta1.HostBackupStats{
+				Hostname: result.backupOptions.Host,
+				Phase:    HostBackupSkipped,
+			})
+		default:
+			backupOptions = append(backupOptions, result.backupOptions)
+		}
+		backupOutput.VaultStats = append(backupOutput.VaultStats, result.vaultStats)
+	}
+
+	if len(backupOptions) == 0 {
+		return backupOutput, nil
+	}
+
+	// the lock can only be checked through the Kubernetes API
+	if opt.kubeClient != nil {
+		if err := resticWrapper.EnsureNoExclusiveLock(opt.kubeClient, opt.namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := resticWrapper.RunParallelBackup(backupOptions, targetRef, maxConcurrency)
+	if out != nil {
+		backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, out.BackupTargetStatus.Stats...)
+	}
+	if err != nil {
+		klog.Errorf("failed to upload backup. Reason: %v", err)
+		// the hosts without an upload status are reported as failed, so that the backup never succeeds without being uploaded
+		for _, o := range backupOptions {
+			if !hasHostStats(backupOutput.BackupTargetStatus.Stats, o.Host) {
+				backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, api_v1beta1.HostBackupStats{
+					Hostname: o.Host,
+					Phase:    api_v1beta1.HostBackupFailed,
+					Error:    err.Error(),
+				})
+			}
+		}
+		return backupOutput, fmt.Errorf("failed to upload backup. Reason: %w", err)
+	}
+
+	return backupOutput, nil
+}
+
+func hasHostStats(stats []api_v1beta1.HostBackupStats, host string) bool {
+	for _, s := range stats {
+		if s.Hostname == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *vaultOptions) failedBackupOutput(targetRef api_v1beta1.TargetRef, err error) *BackupOutput {
+	return &BackupOutput{
+		BackupOutput: restic.BackupOutput{
+			BackupTargetStatus: api_v1beta1.BackupTargetStatus{
+				Ref: targetRef,
+				Stats: []api_v1beta1.HostBackupStats{
+					{
+						Hostname: opt.backupOptions.Host,
+						Phase:    api_v1beta1.HostBackupFailed,
+						Error:    err.Error(),
+					},
+				},
+			},
+		},
+	}
+}
+
+// vaultBackupResult holds the outcome of preparing the backup of a VaultServer
+type vaultBackupResult struct {
+	backupOptions restic.BackupOptions
+	vaultStats    VaultStats
+	skipped       bool
+	err           error
+}
+
+// resolveBackupTarget returns the AppBindings to backup and the Stash target they are reported under.
+// The target of the BackupConfiguration or BackupBatch invoking the backup session is used, so that the output
+// matches the target Stash is waiting for. Without a backup session, a single AppBinding is its own target.
+func (opt *vaultOptions) resolveBackupTarget() ([]appcatalog.AppBinding, api_v1beta1.TargetRef, error) {
+	targetRef := api_v1beta1.TargetRef{
+		APIVersion: appcatalog.SchemeGroupVersion.String(),
+		Kind:       appcatalog.ResourceKindApp,
+		Namespace:  opt.appBindingNamespace,
+	}
+	if len(opt.appBindingNames) != 0 {
+		targetRef.Name = opt.appBindingNames[0]
+	}
+
+	appBindings, err := opt.getAppBindings()
+	if err != nil {
+		return nil, targetRef, err
+	}
+
+	if opt.backupSessionName == "" {
+		if len(appBindings) > 1 {
+			return nil, targetRef, fmt.Errorf("--backupsession must be specified to backup multiple app bindings")
+		}
+		targetRef.Name = appBindings[0].Name
+		targetRef.Namespace = appBindings[0].Namespace
+		return appBindings, targetRef, nil
+	}
+
+	session, err := opt.stashClient.StashV1beta1().BackupSessions(opt.namespace).Get(context.TODO(), opt.backupSessionName, metav1.GetOptions{})
+	if err != nil {
+		return nil, targetRef, err
+	}
+	inv, err := invoker.NewBackupInvoker(opt.stashClient, session.Spec.Invoker.Kind, session.Spec.Invoker.Name, opt.namespace)
+	if err != nil {
+		return nil, targetRef, err
+	}
+
+	var refs []api_v1beta1.TargetRef
+	for _, info := range inv.GetTargetInfo() {
+		if info.Target == nil || info.Target.Ref.Kind != appcatalog.ResourceKindApp {
+			continue
+		}
+		ref := info.Target.Ref
+		if ref.Namespace == "" {
+			ref.Namespace = opt.namespace
+		}
+		if containsAppBinding(appBindings, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) != 1 {
+		return nil, targetRef, fmt.Errorf("expected one AppBinding target of %s %s/%s among the app bindings to backup, found %d",
+			session.Spec.Invoker.Kind, opt.namespace, session.Spec.Invoker.Name, len(refs))
+	}
+
+	return appBindings, refs[0], nil
+}
+
+func containsAppBinding(appBindings []appcatalog.AppBinding, ref api_v1beta1.TargetRef) bool {
+	for _, ab := range appBindings {
+		if ab.Name == ref.Name && ab.Namespace == ref.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// getAppBindings returns the AppBindings of the VaultServers to backup
+func (opt *vaultOptions) getAppBindings() ([]appcatalog.AppBinding, error) {
+	var appBindings []appcatalog.AppBinding
+	for _, name := range opt.appBindingNames {
+		appBinding, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		appBindings = append(appBindings, *appBinding)
+	}
+
+	if opt.appBindingSelector != "" {
+		list, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: opt.appBindingSelector,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			duplicate := false
+			for _, ab := range appBindings {
+				if ab.Name == item.Name {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				appBindings = append(appBindings, item)
+			}
+		}
+	}
+
+	if len(appBindings) == 0 {
+		return nil, fmt.Errorf("no app binding found to backup")
+	}
+	return appBindings, nil
+}
+
+// forAppBinding returns a copy of the options for backing up the given AppBinding.
+// When multiple VaultServers are backed up together, each one uses its own restic host, interim & scratch directory.
+func (opt *vaultOptions) forAppBinding(appBinding *appcatalog.AppBinding, multiple bool) *vaultOptions {
+	o := *opt
+	o.appBindingName = appBinding.Name
+	o.appBindingNamespace = appBinding.Namespace
+	o.backupOptions.Args = append([]string(nil), opt.backupOptions.Args...)
+	if multiple {
+		o.backupOptions.Host = appBinding.Name
+		o.interimDataDir = filepath.Join(opt.interimDataDir, appBinding.Name)
+		o.setupOptions.ScratchDir = filepath.Join(opt.setupOptions.ScratchDir, appBinding.Name)
+	}
+	return &o
+}
+
+// prepareVaultBackup saves the snapshot and the unseal keys of the VaultServer into the interim directory,
+// and returns the options to upload them.
+func (opt *vaultOptions) prepareVaultBackup(resticWrapper *restic.ResticWrapper, appBinding *appcatalog.AppBinding) vaultBackupResult {
+	vaultStats, skipped, err := opt.saveVaultBackup(resticWrapper, appBinding)
+	return opt.newBackupResult(vaultStats, skipped, err)
+}
+
+// newBackupResult returns the options to upload the backup set saved in the interim directory
+func (opt *vaultOptions) newBackupResult(vaultStats VaultStats, skipped bool, err error) vaultBackupResult {
+	result := vaultBackupResult{
+		backupOptions: opt.backupOptions,
+	}
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	result.vaultStats = vaultStats
+	result.skipped = skipped
+	result.backupOptions.BackupPaths = []string{opt.interimDataDir}
+	// record the Vault specific information as snapshot tags
+	result.backupOptions.Args = append(result.backupOptions.Args, vaultStats.tags()...)
+	return result
+}
+
+func (opt *vaultOptions) saveVaultBackup(resticWrapper *restic.ResticWrapper, appBinding *appcatalog.AppBinding) (VaultStats, bool, error) {
 	parameters := vaultconfig.VaultServerConfiguration{}
 	if appBinding.Spec.Parameters != nil {
-		if err = json.Unmarshal(appBinding.Spec.Parameters.Raw, &parameters); err != nil {
-			return nil, fmt.Errorf("unable to unmarshal appBinding.Spec.Parameters.Raw: %w", err)
+		if err := json.Unmarshal(appBinding.Spec.Parameters.Raw, &parameters); err != nil {
+			return VaultStats{}, false, fmt.Errorf("unable to unmarshal appBinding.Spec.Parameters.Raw: %w", err)
 		}
 	}
 
 	// update this while adding support for more backend options for backup (consul, s3, etc.)
 	if parameters.Backend != VaultStorageBackendRaft {
-		return nil, fmt.Errorf("backend must be Raft for backup snapshots")
+		return VaultStats{}, false, fmt.Errorf("backend must be Raft for backup snapshots")
 	}
 
-	if err = clearDir(opt.interimDataDir); err != nil {
-		return nil, err
+	if err := clearDir(opt.interimDataDir); err != nil {
+		return VaultStats{}, false, err
+	}
+	if err := os.MkdirAll(opt.setupOptions.ScratchDir, os.ModePerm); err != nil {
+		return VaultStats{}, false, err
 	}
 
 	session := opt.newSessionWrapper(VaultCMD)
 
 	vaultClient, err := newVaultClient(appBinding)
 	if err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
 	if err := session.setTLSParameters(appBinding, opt.setupOptions.ScratchDir); err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
 	if err := session.waitForVaultReady(vaultClient, opt.waitTimeout); err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
 	if err := session.setVaultToken(opt.kubeClient, appBinding, parameters.BackupTokenSecretRef); err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
 	if err := session.setVaultConnectionParameters(vaultClient, appBinding); err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
 	klog.Infof("Trying to backup for VaultServer %s/%s\n", appBinding.Namespace, appBinding.Name)
 
+	return opt.saveVaultData(resticWrapper, session, vaultClient, opt.newAppBindingTarget(appBinding, parameters))
+}
+
+// saveVaultData saves the snapshot, the unseal keys & root token and the manifest of the connected VaultServer
+// into the interim directory. It reports whether the backup is skipped because nothing has changed since the last backup.
+func (opt *vaultOptions) saveVaultData(resticWrapper *restic.ResticWrapper, session *sessionWrapper, vaultClient *api.Client, target *vaultTarget) (VaultStats, bool, error) {
+	vaultStats := VaultStats{
+		Hostname:   opt.backupOptions.Host,
+		AppBinding: target.name(),
+		Leader:     session.sh.Env[EnvVaultAddress],
+		UnsealMode: target.unsealMode,
+	}
+	if err := vaultStats.setClusterInfo(vaultClient); err != nil {
+		return VaultStats{}, false, err
+	}
+
+	vaultClient.SetToken(session.sh.Env[EnvVaultToken])
+
+	// the Raft index recorded in the latest snapshot of this host, used to skip the backup if nothing has changed since then.
+	// The autopilot state only reports the last log index of the servers, not the index of a snapshot,
+	// so the index is compared with the metadata of the snapshot taken below.
+	var lastStats *VaultStats
+	if !opt.forceBackup {
+		var err error
+		lastStats, err = opt.lastBackupStats(resticWrapper)
+		if err != nil {
+			return VaultStats{}, false, err
+		}
+	}
+
 	if err := opt.saveVaultSnapshot(session); err != nil {
-		return nil, err
+		return VaultStats{}, false, err
 	}
 
-	if err := opt.writeVaultTokenKeys(appBinding, parameters); err != nil {
-		return nil, err
+	if err := vaultStats.setSnapshotInfo(filepath.Join(opt.interimDataDir, VaultSnapshotFile)); err != nil {
+		return VaultStats{}, false, err
 	}
 
-	opt.backupOptions.BackupPaths = []string{opt.interimDataDir}
-	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
+	if !opt.forceBackup {
+		if reason, unchanged := raftIndexUnchanged(lastStats, vaultStats.ClusterID, vaultStats.RaftIndex); unchanged {
+			return skippedVaultStats(vaultStats, reason), true, nil
+		}
+	}
+
+	keys, divergence, err := opt.writeVaultTokenKeys(target)
 	if err != nil {
-		return nil, err
+		return VaultStats{}, false, err
+	}
+	vaultStats.KeyCount = len(keys)
+	vaultStats.StoreDivergence = divergence
+
+	// the backup token may not have access to the Raft configuration, so don't fail the backup for it
+	if err := opt.writeRaftConfiguration(vaultClient); err != nil {
+		klog.Warningf("failed to save Raft configuration. Reason: %v", err)
 	}
-	err = resticWrapper.EnsureNoExclusiveLock(opt.kubeClient, opt.namespace)
+
+	manifest := &vaultManifest{Vault: vaultStats}
+	if err := opt.applySnapshotLayout(manifest); err != nil {
+		return VaultStats{}, false, err
+	}
+	if err := opt.writeManifest(manifest); err != nil {
+		return VaultStats{}, false, err
+	}
+
+	return vaultStats, false, nil
+}
+
+// lastBackupStats returns the Vault information recorded in the latest snapshot of this host.
+// It returns nil if there is no snapshot of this host in the repository.
+func (opt *vaultOptions) lastBackupStats(w *restic.ResticWrapper) (*VaultStats, error) {
+	snapshots, err := w.ListSnapshots(nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to list snapshots. Reason: %w", err)
 	}
 
-	return resticWrapper.RunBackup(opt.backupOptions, targetRef)
+	var latest *restic.Snapshot
+	for i := range snapshots {
+		if snapshots[i].Hostname != opt.backupOptions.Host {
+			continue
+		}
+		if latest == nil || snapshots[i].Time.After(latest.Time) {
+			latest = &snapshots[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	stats := vaultStatsFromTags(latest.Hostname, latest.Tags)
+	stats.SnapshotID = latest.ID
+	return &stats, nil
+}
+
+// raftIndexUnchanged checks whether the given Raft index is the same as the one recorded in the last backup of the same cluster
+func raftIndexUnchanged(last *VaultStats, clusterID string, index uint64) (string, bool) {
+	if last == nil || last.RaftIndex == 0 || index == 0 {
+		return "", false
+	}
+	if last.ClusterID != "" && clusterID != "" && last.ClusterID != clusterID {
+		return "", false
+	}
+	if last.RaftIndex != index {
+		return "", false
+	}
+
+	return fmt.Sprintf("Raft index %d is unchanged since snapshot %s", index, last.SnapshotID), true
+}
+
+func skippedVaultStats(vaultStats VaultStats, reason string) VaultStats {
+	klog.Infof("Skipping backup of host %s. Reason: %s", vaultStats.Hostname, reason)
+
+	vaultStats.Reason = reason
+	return vaultStats
 }
 
 func (opt *vaultOptions) saveVaultSnapshot(session *sessionWrapper) error {
@@ -278,39 +663,46 @@ func (opt *vaultOptions) saveVaultSnapshot(session *sessionWrapper) error {
 	return nil
 }
 
-func (opt *vaultOptions) writeVaultTokenKeys(appBinding *appcatalog.AppBinding, params vaultconfig.VaultServerConfiguration) error {
-	if params.Unsealer == nil {
-		return fmt.Errorf("unsealer spec is nil")
-	}
-
+// writeVaultTokenKeys writes the root token & unseal keys into the interim directory, and returns the differences found between the copies of a mirrored store
+func (opt *vaultOptions) writeVaultTokenKeys(target *vaultTarget) ([]string, []string, error) {
 	klog.Infoln("Trying to get, write unseal keys & root token")
 	// for backup:
 	// i. Get the unseal key & root token from store based on the unseal mode
 	// ii. write them into the interim directory which will be backed up
-	st, err := store.NewStore(opt.kubeClient, appBinding, params.Unsealer)
+	st, err := target.newStore()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	defer closeStore(st)
 
-	var keys []string
-	keys = append(keys, opt.tokenName(opt.keyPrefix))
-	for i := 0; i < int(params.Unsealer.SecretShares); i++ {
+	storePrefix, shares, err := opt.discoverVaultKeys(st, opt.keyPrefix, target.secretShares)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the keys are always written with the configured prefix, so that restore finds them with the same --old-key-prefix
+	storeKeys := map[string]string{
+		opt.tokenName(opt.keyPrefix): opt.tokenName(storePrefix),
+	}
+	keys := []string{opt.tokenName(opt.keyPrefix)}
+	for i := 0; i < shares; i++ {
+		storeKeys[opt.unsealKeyName(opt.keyPrefix, i)] = opt.unsealKeyName(storePrefix, i)
 		keys = append(keys, opt.unsealKeyName(opt.keyPrefix, i))
 	}
 
 	for _, key := range keys {
-		value, err := st.Get(key)
+		value, err := st.Get(storeKeys[key])
 		if err != nil {
-			return fmt.Errorf("failed to get key %s. Reason: %w", key, err)
+			return nil, nil, fmt.Errorf("failed to get key %s. Reason: %w", storeKeys[key], err)
 		}
 
 		if err := opt.write(key, value); err != nil {
-			return fmt.Errorf("failed to write key %s. Reason: %w", key, err)
+			return nil, nil, fmt.Errorf("failed to write key %s. Reason: %w", key, err)
 		}
 	}
 
 	klog.Infoln("Successfully stored unseal keys & root token")
-	return nil
+	return keys, storeDivergence(st), nil
 }
 
 func (opt *vaultOptions) write(key, value string) error {
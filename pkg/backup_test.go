@@ -0,0 +1,83 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"testing"
+)
+
+func TestRaftIndexUnchanged(t *testing.T) {
+	cases := []struct {
+		name      string
+		last      *VaultStats
+		clusterID string
+		index     uint64
+		want      bool
+	}{
+		{
+			name:      "unchanged index",
+			last:      &VaultStats{RaftIndex: 10, ClusterID: "a", SnapshotID: "s1"},
+			clusterID: "a",
+			index:     10,
+			want:      true,
+		},
+		{
+			name:      "changed index",
+			last:      &VaultStats{RaftIndex: 10, ClusterID: "a"},
+			clusterID: "a",
+			index:     11,
+		},
+		{
+			// the same index of a restored or re-created cluster holds different data
+			name:      "different cluster",
+			last:      &VaultStats{RaftIndex: 10, ClusterID: "a"},
+			clusterID: "b",
+			index:     10,
+		},
+		{
+			name:      "cluster id unknown",
+			last:      &VaultStats{RaftIndex: 10},
+			clusterID: "a",
+			index:     10,
+			want:      true,
+		},
+		{
+			name:  "no previous backup",
+			index: 10,
+		},
+		{
+			name: "previous index unknown",
+			last: &VaultStats{},
+		},
+		{
+			name: "current index unknown",
+			last: &VaultStats{RaftIndex: 10},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reason, got := raftIndexUnchanged(c.last, c.clusterID, c.index)
+			if got != c.want {
+				t.Errorf("raftIndexUnchanged() = %v, want %v", got, c.want)
+			}
+			if got && reason == "" {
+				t.Error("raftIndexUnchanged() gave no reason to skip")
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+// TemporaryUnsealKeyKey is the key of the secret holding the unseal key of the temporary barrier of a bootstrap
+const TemporaryUnsealKeyKey = "unseal-key"
+
+// waitForVaultInitStatus waits for the VaultServer to respond, and returns whether it has been initialized
+func waitForVaultInitStatus(vc *api.Client, waitTimeout int32) (bool, error) {
+	klog.Infoln("Waiting for the vault to respond....")
+
+	var initialized bool
+	err := wait.PollUntilContextTimeout(context.Background(), 5*time.Second, time.Duration(waitTimeout)*time.Second, true, func(ctx context.Context) (done bool, err error) {
+		initialized, err = vc.Sys().InitStatus()
+		if err != nil {
+			klog.Infof("Unable to connect with the VaultServer. Reason: %v.\nRetrying after 5 seconds....", err)
+			return false, nil
+		}
+		return true, nil
+	})
+	return initialized, err
+}
+
+// bootstrapVaultCluster restores the snapshot into an uninitialized VaultServer.
+// The first pod is initialized with a temporary single key barrier, the snapshot is forcefully restored on it
+// and it is unsealed with the backed up unseal keys. Then the remaining pods join the first one using raft join.
+// The unsealer of the VaultServer would initialize the cluster concurrently, so it must be stopped first.
+func (opt *vaultOptions) bootstrapVaultCluster(session *sessionWrapper, vc *api.Client, appBinding *appcatalog.AppBinding) error {
+	klog.Infof("VaultServer %s/%s is not initialized, trying to bootstrap it from the snapshot", appBinding.Namespace, appBinding.Name)
+
+	unsealKeys, err := opt.backedUpUnsealKeys()
+	if err != nil {
+		return err
+	}
+
+	pods, err := opt.vaultPods(appBinding)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return fmt.Errorf("no pod found behind the service of AppBinding %s/%s", appBinding.Namespace, appBinding.Name)
+	}
+	if err := ensureNoUnsealer(pods); err != nil {
+		return err
+	}
+
+	port, err := appBinding.Port()
+	if err != nil {
+		return err
+	}
+	scheme := appBinding.Spec.ClientConfig.Service.Scheme
+
+	leaderAddr := podAddress(pods[0], scheme, port)
+	leader, err := podClient(vc, leaderAddr)
+	if err != nil {
+		return err
+	}
+
+	keySecret := temporaryUnsealKeySecret(appBinding)
+	if err := opt.ensureNoTemporaryUnsealKey(keySecret); err != nil {
+		return err
+	}
+
+	klog.Infof("Initializing %s with a temporary barrier", pods[0].Name)
+	resp, err := leader.Sys().Init(&api.InitRequest{
+		SecretShares:    1,
+		SecretThreshold: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s. Reason: %w", pods[0].Name, err)
+	}
+
+	// the snapshot replaces the temporary barrier along with its root token. Until then, a failure leaves the pod
+	// initialized with the temporary barrier, so its unseal key is kept in a secret to recover the pod and the root token is revoked.
+	restored := false
+	defer func() {
+		if restored {
+			return
+		}
+		if err := revokeToken(leader, resp.RootToken); err != nil {
+			klog.Warningf("failed to revoke root token of the temporary barrier. Reason: %v", err)
+		}
+	}()
+
+	keySecret.Data = map[string][]byte{TemporaryUnsealKeyKey: []byte(resp.Keys[0])}
+	if _, err := opt.kubeClient.CoreV1().Secrets(keySecret.Namespace).Create(context.TODO(), keySecret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to save unseal key of the temporary barrier in secret %s/%s, %s must be reset before retrying. Reason: %w",
+			keySecret.Namespace, keySecret.Name, pods[0].Name, err)
+	}
+	defer func() {
+		if restored {
+			return
+		}
+		klog.Errorf("Bootstrap of %s failed, it is left initialized with a temporary barrier. The unseal key of the temporary barrier is kept in the %s key of secret %s/%s",
+			pods[0].Name, TemporaryUnsealKeyKey, keySecret.Namespace, keySecret.Name)
+	}()
+
+	if err := unsealVault(leader, resp.Keys, opt.waitTimeout); err != nil {
+		return fmt.Errorf("failed to unseal %s with the temporary barrier. Reason: %w", pods[0].Name, err)
+	}
+	if err := waitForActiveVault(leader, opt.waitTimeout); err != nil {
+		return err
+	}
+
+	// the snapshot belongs to a different cluster than the temporary barrier, so it must be restored forcefully
+	session.sh.SetEnv(EnvVaultAddress, leaderAddr)
+	session.sh.SetEnv(EnvVaultToken, resp.RootToken)
+	if err := opt.restoreVaultSnapshot(session, true); err != nil {
+		return err
+	}
+	restored = true
+
+	// the temporary barrier is gone along with its unseal key
+	if err := opt.kubeClient.CoreV1().Secrets(keySecret.Namespace).Delete(context.TODO(), keySecret.Name, metav1.DeleteOptions{}); err != nil {
+		klog.Warningf("failed to delete secret %s/%s of the temporary barrier. Reason: %v", keySecret.Namespace, keySecret.Name, err)
+	}
+
+	if err := unsealVault(leader, unsealKeys, opt.waitTimeout); err != nil {
+		return fmt.Errorf("failed to unseal %s with the backed up unseal keys. Reason: %w", pods[0].Name, err)
+	}
+
+	var caCert string
+	if appBinding.Spec.ClientConfig.CABundle != nil {
+		caCert = string(appBinding.Spec.ClientConfig.CABundle)
+	}
+	for _, pod := range pods[1:] {
+		if err := joinVaultCluster(vc, podAddress(pod, scheme, port), leaderAddr, caCert, unsealKeys, opt.waitTimeout); err != nil {
+			return fmt.Errorf("failed to join %s to the cluster. Reason: %w", pod.Name, err)
+		}
+	}
+
+	klog.Infof("VaultServer %s/%s bootstrapped successfully", appBinding.Namespace, appBinding.Name)
+	return nil
+}
+
+// ensureNoUnsealer checks that the unsealer sidecar of KubeVault is not running in any of the pods
+func ensureNoUnsealer(pods []core.Pod) error {
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == vaultapi.VaultUnsealerContainerName && status.State.Running != nil {
+				return fmt.Errorf("container %s is running in pod %s, remove the unsealer from the VaultServer before bootstrapping it, "+
+					"otherwise the unsealer initializes the cluster concurrently", status.Name, pod.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// temporaryUnsealKeySecret returns the secret that keeps the unseal key of the temporary barrier until the snapshot replaces it
+func temporaryUnsealKeySecret(appBinding *appcatalog.AppBinding) *core.Secret {
+	return &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-bootstrap-unseal-key", appBinding.Name),
+			Namespace: appBinding.Namespace,
+		},
+		Type: core.SecretTypeOpaque,
+	}
+}
+
+// ensureNoTemporaryUnsealKey checks that the secret of the temporary barrier is not left by a previous bootstrap,
+// whose pod may still need the key to be recovered
+func (opt *vaultOptions) ensureNoTemporaryUnsealKey(secret *core.Secret) error {
+	_, err := opt.kubeClient.CoreV1().Secrets(secret.Namespace).Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("secret %s/%s keeps the unseal key of the temporary barrier of a failed bootstrap, "+
+		"delete it once the pod is reset before bootstrapping again", secret.Namespace, secret.Name)
+}
+
+func revokeToken(vc *api.Client, token string) error {
+	client, err := vc.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	return client.Auth().Token().RevokeSelf("")
+}
+
+// backedUpUnsealKeys reads the unseal keys of the backup set from the interim directory
+func (opt *vaultOptions) backedUpUnsealKeys() ([]string, error) {
+	var keys []string
+	for i := 0; ; i++ {
+		key, err := opt.read(opt.unsealKeyName(opt.oldKeyPrefix, i))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("backup does not have any unseal key with prefix %q", opt.oldKeyPrefix)
+	}
+	return keys, nil
+}
+
+func joinVaultCluster(vc *api.Client, addr, leaderAddr, caCert string, unsealKeys []string, waitTimeout int32) error {
+	client, err := podClient(vc, addr)
+	if err != nil {
+		return err
+	}
+
+	initialized, err := waitForVaultInitStatus(client, waitTimeout)
+	if err != nil {
+		return err
+	}
+	if initialized {
+		klog.Infof("%s is already initialized, skipping raft join", addr)
+		return nil
+	}
+
+	klog.Infof("Joining %s to the cluster", addr)
+	resp, err := client.Sys().RaftJoin(&api.RaftJoinRequest{
+		LeaderAPIAddr: leaderAddr,
+		LeaderCACert:  caCert,
+		Retry:         true,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Joined {
+		return fmt.Errorf("%s did not join the cluster", addr)
+	}
+
+	// a joined node completes the join after it is unsealed
+	return unsealVault(client, unsealKeys, waitTimeout)
+}
+
+// unsealVault submits the unseal keys until the VaultServer is unsealed. The unseal progress left by others is reset
+// once before the keys are submitted, a retry continues with the keys that the VaultServer has not accepted yet.
+func unsealVault(vc *api.Client, keys []string, waitTimeout int32) error {
+	reset := false
+	return wait.PollUntilContextTimeout(context.Background(), 5*time.Second, time.Duration(waitTimeout)*time.Second, true, func(ctx context.Context) (done bool, err error) {
+		status, err := vc.Sys().SealStatus()
+		if err != nil {
+			klog.Infof("Unable to read seal status. Reason: %v.\nRetrying after 5 seconds....", err)
+			return false, nil
+		}
+		if !status.Sealed {
+			return true, nil
+		}
+
+		if !reset {
+			if status, err = vc.Sys().ResetUnsealProcess(); err != nil {
+				klog.Infof("Unable to reset the unseal progress. Reason: %v.\nRetrying after 5 seconds....", err)
+				return false, nil
+			}
+			reset = true
+		}
+
+		// the keys are submitted in order after the reset, so the keys accepted by the VaultServer are the first ones
+		submitted := status.Progress
+		if submitted > len(keys) {
+			submitted = 0
+		}
+		for _, key := range keys[submitted:] {
+			status, err = vc.Sys().Unseal(key)
+			if err != nil {
+				klog.Infof("Unable to unseal the VaultServer. Reason: %v.\nRetrying after 5 seconds....", err)
+				return false, nil
+			}
+			if !status.Sealed {
+				klog.Infoln("VaultServer unsealed successfully")
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+func waitForActiveVault(vc *api.Client, waitTimeout int32) error {
+	return wait.PollUntilContextTimeout(context.Background(), 5*time.Second, time.Duration(waitTimeout)*time.Second, true, func(ctx context.Context) (done bool, err error) {
+		resp, err := vc.Sys().Health()
+		if err != nil || resp == nil || resp.Sealed || resp.Standby {
+			klog.Infoln("Waiting for the VaultServer to become active. Retrying after 5 seconds....")
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// podAddress returns the API address of the pod, using the DNS name given by the governing service if there is any
+func podAddress(pod core.Pod, scheme string, port int32) string {
+	host := pod.Status.PodIP
+	if pod.Spec.Hostname != "" && pod.Spec.Subdomain != "" {
+		host = fmt.Sprintf("%s.%s.%s.svc", pod.Spec.Hostname, pod.Spec.Subdomain, pod.Namespace)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
+
+func podClient(vc *api.Client, addr string) (*api.Client, error) {
+	client, err := vc.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetAddress(addr); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
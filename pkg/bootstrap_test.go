@@ -0,0 +1,164 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// fakeSealedVault serves the seal status & unseal API of a sealed Vault with a threshold of two keys
+type fakeSealedVault struct {
+	lock     sync.Mutex
+	sealed   bool
+	progress []string
+	// failures is the number of requests to fail that submit the second key
+	failures int
+	resets   int
+}
+
+func (v *fakeSealedVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if r.URL.Path == "/v1/sys/unseal" {
+		var req struct {
+			Key   string `json:"key"`
+			Reset bool   `json:"reset"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case req.Reset:
+			v.progress = nil
+			v.resets++
+		case v.failures > 0 && len(v.progress) == 1:
+			v.failures--
+			http.Error(w, `{"errors":["unavailable"]}`, http.StatusInternalServerError)
+			return
+		default:
+			for _, key := range v.progress {
+				if key == req.Key {
+					http.Error(w, `{"errors":["key already entered"]}`, http.StatusBadRequest)
+					return
+				}
+			}
+			v.progress = append(v.progress, req.Key)
+			if len(v.progress) == 2 {
+				v.sealed, v.progress = false, nil
+			}
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(api.SealStatusResponse{Sealed: v.sealed, T: 2, N: 2, Progress: len(v.progress)})
+}
+
+func TestUnsealVault(t *testing.T) {
+	cases := []struct {
+		name       string
+		vault      *fakeSealedVault
+		wantResets int
+	}{
+		{
+			name:  "unsealed",
+			vault: &fakeSealedVault{},
+		},
+		{
+			name:       "progress of others is reset once",
+			vault:      &fakeSealedVault{sealed: true, progress: []string{"other"}},
+			wantResets: 1,
+		},
+		{
+			// a reset on retry would discard the key accepted before the failure
+			name:       "retry continues with the keys not accepted yet",
+			vault:      &fakeSealedVault{sealed: true, failures: 1},
+			wantResets: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(c.vault)
+			defer srv.Close()
+
+			config := api.DefaultConfig()
+			config.Address = srv.URL
+			config.MaxRetries = 0
+			vc, err := api.NewClient(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := unsealVault(vc, []string{"key-0", "key-1"}, 30); err != nil {
+				t.Fatal(err)
+			}
+			if c.vault.sealed {
+				t.Error("vault is still sealed")
+			}
+			if c.vault.resets != c.wantResets {
+				t.Errorf("unseal progress reset %d times, want %d", c.vault.resets, c.wantResets)
+			}
+		})
+	}
+}
+
+func TestEnsureNoTemporaryUnsealKey(t *testing.T) {
+	appBinding := &appcatalog.AppBinding{ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"}}
+
+	cases := []struct {
+		name    string
+		objects []*core.Secret
+		wantErr bool
+	}{
+		{
+			name: "no secret",
+		},
+		{
+			name:    "secret of a failed bootstrap",
+			objects: []*core.Secret{temporaryUnsealKeySecret(appBinding)},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kc := fake.NewSimpleClientset()
+			for _, secret := range c.objects {
+				if err := kc.Tracker().Add(secret); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opt := &vaultOptions{kubeClient: kc}
+
+			err := opt.ensureNoTemporaryUnsealKey(temporaryUnsealKeySecret(appBinding))
+			if (err != nil) != c.wantErr {
+				t.Errorf("ensureNoTemporaryUnsealKey() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
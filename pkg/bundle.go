@@ -0,0 +1,492 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/pbkdf2"
+	"gomodules.xyz/flags"
+	"k8s.io/klog/v2"
+)
+
+// An offline DR bundle is a tar archive of the backup set, encrypted with a key derived from a passphrase.
+// The archive is sealed in chunks with AES-256-GCM, so that a bundle of any size can be streamed.
+const (
+	bundleMagic      = "STASHVAULTBUNDLE"
+	bundleVersion    = 1
+	bundleSaltSize   = 16
+	bundleIterations = 600000
+	bundleChunkSize  = 64 * 1024
+
+	// the iteration count is read from the bundle header, so it is bounded before a key is derived from it
+	bundleMinIterations = 100000
+	bundleMaxIterations = 10000000
+
+	// StdStream makes export-bundle write to stdout, and restore-vault read the bundle from stdin
+	StdStream = "-"
+)
+
+// bundleWriter encrypts the written data into the bundle format
+type bundleWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newBundleWriter(w io.Writer, passphrase []byte) (*bundleWriter, error) {
+	salt := make([]byte, bundleSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := bundleAEAD(passphrase, salt, bundleIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString(bundleMagic)
+	header.WriteByte(bundleVersion)
+	_ = binary.Write(header, binary.BigEndian, uint32(bundleIterations))
+	header.Write(salt)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &bundleWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, bundleChunkSize),
+	}, nil
+}
+
+func (bw *bundleWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// keep the last chunk buffered until Close, so that it can be sealed as the final one
+		if len(bw.buf) == bundleChunkSize {
+			if err := bw.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(bw.buf[len(bw.buf):bundleChunkSize], p)
+		bw.buf = bw.buf[:len(bw.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (bw *bundleWriter) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	return bw.seal(true)
+}
+
+func (bw *bundleWriter) seal(final bool) error {
+	sealed := bw.aead.Seal(nil, bundleNonce(bw.counter, final), bw.buf, nil)
+	if err := binary.Write(bw.w, binary.BigEndian, uint32(len(sealed))); err != nil {
+		return err
+	}
+	if _, err := bw.w.Write(sealed); err != nil {
+		return err
+	}
+	bw.counter++
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+// bundleReader decrypts a bundle written by bundleWriter. A truncated bundle is reported as an error.
+type bundleReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	final   bool
+}
+
+func newBundleReader(r io.Reader, passphrase []byte) (*bundleReader, error) {
+	header := make([]byte, len(bundleMagic)+1+4+bundleSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read bundle header. Reason: %w", err)
+	}
+	if string(header[:len(bundleMagic)]) != bundleMagic {
+		return nil, fmt.Errorf("not a Vault backup bundle")
+	}
+	header = header[len(bundleMagic):]
+	if header[0] != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", header[0])
+	}
+	iterations := binary.BigEndian.Uint32(header[1:5])
+	if iterations < bundleMinIterations || iterations > bundleMaxIterations {
+		return nil, fmt.Errorf("bundle key iteration count %d is out of the range [%d, %d]", iterations, bundleMinIterations, bundleMaxIterations)
+	}
+	salt := header[5:]
+
+	aead, err := bundleAEAD(passphrase, salt, int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	return &bundleReader{r: r, aead: aead}, nil
+}
+
+func (br *bundleReader) Read(p []byte) (int, error) {
+	for len(br.buf) == 0 {
+		if br.final {
+			return 0, io.EOF
+		}
+		if err := br.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.buf)
+	br.buf = br.buf[n:]
+	return n, nil
+}
+
+func (br *bundleReader) open() error {
+	var size uint32
+	if err := binary.Read(br.r, binary.BigEndian, &size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("bundle is truncated")
+		}
+		return err
+	}
+	if size > bundleChunkSize+uint32(br.aead.Overhead()) {
+		return fmt.Errorf("bundle chunk of %d bytes exceeds the maximum chunk size", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(br.r, sealed); err != nil {
+		return fmt.Errorf("bundle is truncated. Reason: %w", err)
+	}
+
+	// the final chunk is sealed with a different nonce, so try it only when the regular one does not match
+	for _, final := range []bool{false, true} {
+		plain, err := br.aead.Open(nil, bundleNonce(br.counter, final), sealed, nil)
+		if err == nil {
+			br.buf = plain
+			br.final = final
+			br.counter++
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to decrypt bundle, either the passphrase is wrong or the bundle is corrupted")
+}
+
+func bundleAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func bundleNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func readPassphrase(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase. Reason: %w", err)
+	}
+	passphrase := strings.TrimRight(string(data), "\r\n")
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase file %s is empty", path)
+	}
+	return []byte(passphrase), nil
+}
+
+func NewCmdExportBundle() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		snapshotID     string
+		outputFile     = StdStream
+		passphraseFile string
+
+		opt = vaultOptions{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "export-bundle",
+		Short:             "Exports a Vault backup as an encrypted offline bundle",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "snapshot", "passphrase-file", "provider", "storage-secret-name", "storage-secret-namespace")
+
+			passphrase, err := readPassphrase(passphraseFile)
+			if err != nil {
+				return err
+			}
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+
+			resticWrapper, err := opt.newRepositoryWrapper()
+			if err != nil {
+				return err
+			}
+
+			return opt.exportBundle(resticWrapper, snapshotID, outputFile, passphrase)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	opt.addRepositoryFlags(cmd)
+
+	cmd.Flags().StringVar(&snapshotID, "snapshot", snapshotID, "Snapshot to export")
+	cmd.Flags().StringVar(&outputFile, "output-file", outputFile, "Path of the bundle to write (- for stdout)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", passphraseFile, "Path of the file holding the passphrase to encrypt the bundle")
+
+	return cmd
+}
+
+// exportBundle downloads the snapshot and writes its backup set as an encrypted bundle.
+// An unpacked snapshot is reassembled, so that the bundle always holds the snapshot archive.
+func (opt *vaultOptions) exportBundle(w *restic.ResticWrapper, snapshotID, outputFile string, passphrase []byte) error {
+	tmpDir, dir, snapshot, err := opt.fetchBackupSet(w, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = &vaultManifest{
+			Layout: SnapshotLayoutArchive,
+			Vault:  vaultStatsFromTags(snapshot.Hostname, snapshot.Tags),
+		}
+	}
+	if manifest.Layout == SnapshotLayoutUnpacked {
+		if err := packSnapshot(filepath.Join(dir, VaultSnapshotDir), manifest.Entries, filepath.Join(dir, VaultSnapshotFile)); err != nil {
+			return fmt.Errorf("failed to reassemble snapshot. Reason: %w", err)
+		}
+		manifest.Layout = SnapshotLayoutArchive
+		manifest.Entries = nil
+	}
+	manifest.Vault.SnapshotID = snapshot.ID
+
+	files := []string{VaultSnapshotFile, VaultRaftConfigurationFile, VaultAutopilotConfigurationFile}
+	keys, err := listKeyFiles(dir)
+	if err != nil {
+		return err
+	}
+	files = append(files, keys...)
+
+	if outputFile == StdStream {
+		err = writeBundle(os.Stdout, dir, manifest, files, passphrase)
+	} else {
+		err = writeBundleFile(outputFile, dir, manifest, files, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Snapshot %s exported successfully with %d key(s)", snapshot.ID, len(keys))
+	return nil
+}
+
+// writeBundleFile writes the bundle to a temporary file that is renamed into place once it is complete,
+// so that a failed export never leaves a truncated bundle behind
+func writeBundleFile(outputFile, dir string, manifest *vaultManifest, files []string, passphrase []byte) error {
+	out, err := os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
+
+	if err = writeBundle(out, dir, manifest, files, passphrase); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(out.Name(), outputFile); err != nil {
+		return fmt.Errorf("failed to write bundle %s. Reason: %w", outputFile, err)
+	}
+	return nil
+}
+
+// writeBundle writes the manifest and the files of the backup set as an encrypted bundle
+func writeBundle(out io.Writer, dir string, manifest *vaultManifest, files []string, passphrase []byte) error {
+	bw, err := newBundleWriter(out, passphrase)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(bw)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarEntry(tw, VaultManifestFile, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := writeTarFile(tw, dir, name); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+// writeTarFile adds the file of the backup set to the archive, the optional files that don't exist are skipped
+func writeTarFile(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) && name != VaultSnapshotFile {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarEntry(tw, name, f, fi.Size())
+}
+
+func writeTarEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o600,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// extractBundle decrypts the bundle into the interim directory, in place of restoring the backup set from the repository
+func (opt *vaultOptions) extractBundle(targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, error) {
+	startTime := time.Now()
+
+	passphrase, err := readPassphrase(opt.bundlePassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	in := os.Stdin
+	if opt.bundleFile != StdStream {
+		in, err = os.Open(opt.bundleFile)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+	}
+
+	br, err := newBundleReader(in, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Extracting bundle %s", opt.bundleFile)
+	tr := tar.NewReader(br)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle. Reason: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := validateEntryName(hdr.Name); err != nil {
+			return nil, err
+		}
+		if _, err := writeEntry(filepath.Join(opt.interimDataDir, hdr.Name), tr); err != nil {
+			return nil, fmt.Errorf("failed to extract %s from bundle. Reason: %w", hdr.Name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(opt.interimDataDir, VaultSnapshotFile)); err != nil {
+		return nil, fmt.Errorf("bundle does not have any snapshot. Reason: %w", err)
+	}
+
+	return &restic.RestoreOutput{
+		RestoreTargetStatus: api_v1beta1.RestoreMemberStatus{
+			Ref: targetRef,
+			Stats: []api_v1beta1.HostRestoreStats{
+				{
+					Hostname: opt.restoreOptions.Host,
+					Phase:    api_v1beta1.HostRestoreSucceeded,
+					Duration: time.Since(startTime).String(),
+				},
+			},
+		},
+	}, nil
+}
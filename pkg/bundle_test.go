@@ -0,0 +1,183 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+func sealTestBundle(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	bw, err := newBundleWriter(buf, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openTestBundle(bundle, passphrase []byte) ([]byte, error) {
+	br, err := newBundleReader(bytes.NewReader(bundle), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(br)
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "single byte", size: 1},
+		{name: "one chunk", size: bundleChunkSize},
+		{name: "one chunk and a byte", size: bundleChunkSize + 1},
+		{name: "several chunks", size: 3*bundleChunkSize + 17},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := make([]byte, c.size)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := openTestBundle(sealTestBundle(t, data), testPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes, want the %d written bytes", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestBundleReaderErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("vault"), bundleChunkSize)
+	bundle := sealTestBundle(t, data)
+	headerSize := len(bundleMagic) + 1 + 4 + bundleSaltSize
+
+	withIterations := func(iterations uint32) []byte {
+		b := append([]byte(nil), bundle...)
+		binary.BigEndian.PutUint32(b[len(bundleMagic)+1:], iterations)
+		return b
+	}
+	corrupted := append([]byte(nil), bundle...)
+	corrupted[headerSize+10] ^= 0xff
+
+	cases := []struct {
+		name       string
+		bundle     []byte
+		passphrase []byte
+	}{
+		{name: "wrong passphrase", bundle: bundle, passphrase: []byte("wrong")},
+		{name: "truncated header", bundle: bundle[:headerSize-1], passphrase: testPassphrase},
+		{name: "no chunks", bundle: bundle[:headerSize], passphrase: testPassphrase},
+		{name: "truncated chunk", bundle: bundle[:len(bundle)-1], passphrase: testPassphrase},
+		// the final chunk is missing, though every remaining chunk is intact
+		{name: "truncated at a chunk boundary", bundle: bundle[:headerSize+4+bundleChunkSize+16], passphrase: testPassphrase},
+		{name: "corrupted chunk", bundle: corrupted, passphrase: testPassphrase},
+		{name: "not a bundle", bundle: bytes.Repeat([]byte{0}, len(bundle)), passphrase: testPassphrase},
+		{name: "too few iterations", bundle: withIterations(bundleMinIterations - 1), passphrase: testPassphrase},
+		{name: "too many iterations", bundle: withIterations(bundleMaxIterations + 1), passphrase: testPassphrase},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := openTestBundle(c.bundle, c.passphrase); err == nil {
+				t.Error("bundle was read without an error")
+			}
+		})
+	}
+}
+
+func TestWriteBundleFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "complete backup set",
+			files: map[string]string{VaultSnapshotFile: "snapshot", "k8s.a-root-token": "token"},
+		},
+		{
+			name:    "snapshot missing",
+			files:   map[string]string{"k8s.a-root-token": "token"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, outDir := t.TempDir(), t.TempDir()
+			for name, data := range c.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			outputFile := filepath.Join(outDir, "vault.bundle")
+			// a previous bundle must survive a failed export
+			if err := os.WriteFile(outputFile, []byte("previous"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			manifest := &vaultManifest{Layout: SnapshotLayoutArchive}
+			err := writeBundleFile(outputFile, dir, manifest, []string{VaultSnapshotFile, "k8s.a-root-token"}, testPassphrase)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("writeBundleFile() error = %v, wantErr %v", err, c.wantErr)
+			}
+
+			entries, err := os.ReadDir(outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("output directory has %d entries, want only the bundle", len(entries))
+			}
+			data, err := os.ReadFile(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.wantErr {
+				if string(data) != "previous" {
+					t.Error("failed export replaced the previous bundle")
+				}
+				return
+			}
+			if _, err := openTestBundle(data, testPassphrase); err != nil {
+				t.Errorf("failed to read the written bundle: %v", err)
+			}
+		})
+	}
+}
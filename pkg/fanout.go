@@ -0,0 +1,159 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+	"sigs.k8s.io/yaml"
+)
+
+// secondaryStoreSpec is an unsealer mode the unseal keys & root token are mirrored into.
+// Kubeconfig & Namespace select another cluster (i.e. a DR cluster) for the kubernetesSecret mode & the credential secrets.
+type secondaryStoreSpec struct {
+	vaultapi.ModeSpec `json:",inline"`
+
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+
+	// parameters replace the app binding parameters for the secondary store, so that it reads its own options
+	// (i.e. roleARN of awsKmsSsm) & the modes unknown to the vendored unsealer spec (i.e. vaultTransit) instead of the primary's
+	parameters *runtime.RawExtension
+}
+
+func (opt *vaultOptions) addSecondaryStoreFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&opt.secondaryStoreFiles, "secondary-store-file", opt.secondaryStoreFiles, "Paths of the YAML/JSON unsealer modes the unseal keys & root token are mirrored into, read in order when the unsealer store fails")
+}
+
+// withSecondaryStores mirrors the primary store into the secondary stores, if there is any
+func (opt *vaultOptions) withSecondaryStores(primary store.StoreInterface, appBinding *appcatalog.AppBinding, unsealer *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+	if len(opt.secondaryStoreFiles) == 0 {
+		return primary, nil
+	}
+
+	var secondaries []store.Member
+	for i, path := range opt.secondaryStoreFiles {
+		spec, err := readSecondaryStoreSpec(path)
+		if err != nil {
+			return nil, err
+		}
+
+		st, err := opt.newSecondaryStore(spec, appBinding)
+		if err != nil {
+			for _, m := range secondaries {
+				closeStore(m.Store)
+			}
+			return nil, fmt.Errorf("failed to create secondary store %s. Reason: %w", path, err)
+		}
+		secondaries = append(secondaries, store.Member{
+			Name:  fmt.Sprintf("secondary-%d/%s", i+1, spec.mode(appBinding)),
+			Store: st,
+		})
+	}
+
+	return store.NewFanoutStore(store.Member{
+		Name:  "primary/" + store.Mode(appBinding, unsealer),
+		Store: primary,
+	}, secondaries...), nil
+}
+
+// appBinding returns the copy of the app binding the secondary store is created with
+func (spec *secondaryStoreSpec) appBinding(appBinding *appcatalog.AppBinding) *appcatalog.AppBinding {
+	appBinding = appBinding.DeepCopy()
+	// the stores read the credential secrets & keep the kubernetes secret in the namespace of the app binding
+	if spec.Namespace != "" {
+		appBinding.Namespace = spec.Namespace
+	}
+	appBinding.Spec.Parameters = spec.parameters
+	return appBinding
+}
+
+func (spec *secondaryStoreSpec) unsealer() *vaultapi.UnsealerSpec {
+	return &vaultapi.UnsealerSpec{Mode: spec.ModeSpec}
+}
+
+// mode returns the unseal mode of the secondary store
+func (spec *secondaryStoreSpec) mode(appBinding *appcatalog.AppBinding) string {
+	return store.Mode(spec.appBinding(appBinding), spec.unsealer())
+}
+
+func (opt *vaultOptions) newSecondaryStore(spec *secondaryStoreSpec, appBinding *appcatalog.AppBinding) (store.StoreInterface, error) {
+	kc := opt.kubeClient
+	if spec.Kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", spec.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		if kc, err = kubernetes.NewForConfig(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return store.NewStore(kc, spec.appBinding(appBinding), spec.unsealer())
+}
+
+func readSecondaryStoreSpec(path string) (*secondaryStoreSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &secondaryStoreSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("failed to decode secondary store %s. Reason: %w", path, err)
+	}
+
+	// the mode of the secondary store is passed to the store as the unsealer mode of the app binding parameters
+	mode := map[string]json.RawMessage{}
+	if err := yaml.Unmarshal(data, &mode); err != nil {
+		return nil, fmt.Errorf("failed to decode secondary store %s. Reason: %w", path, err)
+	}
+	delete(mode, "kubeconfig")
+	delete(mode, "namespace")
+	raw, err := json.Marshal(map[string]interface{}{
+		"unsealer": map[string]interface{}{
+			"mode": mode,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	spec.parameters = &runtime.RawExtension{Raw: raw}
+
+	if spec.mode(&appcatalog.AppBinding{}) == "" {
+		return nil, fmt.Errorf("secondary store %s does not specify any known mode", path)
+	}
+	return spec, nil
+}
+
+// storeDivergence returns the differences found between the copies of a mirrored store
+func storeDivergence(st store.StoreInterface) []string {
+	if f, ok := st.(*store.FanoutStore); ok {
+		return f.Divergence()
+	}
+	return nil
+}
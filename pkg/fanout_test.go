@@ -0,0 +1,115 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stash.appscode.dev/vault/pkg/store/transit"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+func TestReadSecondaryStoreSpec(t *testing.T) {
+	// the primary is a transit store with aws options, none of them must reach the secondaries
+	primary := &appcatalog.AppBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"},
+		Spec: appcatalog.AppBindingSpec{
+			Parameters: &runtime.RawExtension{Raw: []byte(`{"unsealer":{"mode":{
+				"vaultTransit":{"address":"https://primary:8200","keyName":"unseal"},
+				"awsKmsSsm":{"roleARN":"arn:aws:iam::1:role/primary"}}}}`)},
+		},
+	}
+
+	cases := []struct {
+		name      string
+		file      string
+		mode      string
+		namespace string
+		transit   string
+		wantErr   string
+	}{
+		{
+			name:      "vendored mode",
+			file:      "kubernetesSecret:\n  secretName: keys\nnamespace: dr\n",
+			mode:      "kubernetesSecret",
+			namespace: "dr",
+		},
+		{
+			name:      "registered mode",
+			file:      "vaultTransit:\n  address: https://dr:8200\n  keyName: unseal\n",
+			mode:      transit.ModeVaultTransit,
+			namespace: "demo",
+			transit:   "https://dr:8200",
+		},
+		{
+			name:    "no mode",
+			file:    "namespace: dr\n",
+			wantErr: "does not specify any known mode",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secondary.yaml")
+			if err := os.WriteFile(path, []byte(c.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			spec, err := readSecondaryStoreSpec(path)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mode := spec.mode(primary); mode != c.mode {
+				t.Errorf("expected mode %q, got %q", c.mode, mode)
+			}
+			appBinding := spec.appBinding(primary)
+			if appBinding.Namespace != c.namespace {
+				t.Errorf("expected namespace %q, got %q", c.namespace, appBinding.Namespace)
+			}
+			if strings.Contains(string(appBinding.Spec.Parameters.Raw), "primary") {
+				t.Errorf("parameters of the primary leaked into the secondary: %s", appBinding.Spec.Parameters.Raw)
+			}
+
+			transitSpec, err := transit.FromAppBinding(appBinding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case c.transit == "" && transitSpec != nil:
+				t.Errorf("expected no transit spec, got %+v", transitSpec)
+			case c.transit != "" && (transitSpec == nil || transitSpec.Address != c.transit):
+				t.Errorf("expected transit address %q, got %+v", c.transit, transitSpec)
+			}
+		})
+	}
+	if primary.Namespace != "demo" {
+		t.Errorf("app binding of the primary was modified")
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+	"k8s.io/klog/v2"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// importOptions holds the snapshot & key material taken outside Stash
+type importOptions struct {
+	snapshotFile   string
+	rootTokenFile  string
+	unsealKeyFiles []string
+	unsealMode     string
+	vaultVersion   string
+}
+
+func NewCmdImportSnapshot() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		imp            importOptions
+
+		opt = vaultOptions{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+			backupOptions: restic.BackupOptions{
+				Host: restic.DefaultHost,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "import-snapshot",
+		Short:             "Imports a Vault Raft snapshot taken outside Stash into the repository",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "snapshot-file", "appbinding", "appbinding-namespace", "interim-data-dir", "provider", "storage-secret-name", "storage-secret-namespace")
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+
+			backupOutput, err := opt.importSnapshot(imp)
+			if err != nil {
+				return err
+			}
+			// If output directory specified, then write the output in "output.json" file in the specified directory
+			if opt.outputDir != "" {
+				return backupOutput.WriteOutput(filepath.Join(opt.outputDir, restic.DefaultOutputFileName))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	opt.addRepositoryFlags(cmd)
+
+	cmd.Flags().StringVar(&imp.snapshotFile, "snapshot-file", imp.snapshotFile, "Path of the snapshot saved by 'vault operator raft snapshot save' or by Vault automated snapshots")
+	cmd.Flags().StringVar(&imp.rootTokenFile, "root-token-file", imp.rootTokenFile, "Path of the file holding the root token of the snapshot")
+	cmd.Flags().StringSliceVar(&imp.unsealKeyFiles, "unseal-key-file", imp.unsealKeyFiles, "Paths of the files holding the unseal keys of the snapshot, in order")
+	cmd.Flags().StringVar(&imp.unsealMode, "unseal-mode", imp.unsealMode, "Unseal mode of the VaultServer the snapshot was taken from")
+	cmd.Flags().StringVar(&imp.vaultVersion, "vault-version", imp.vaultVersion, "Version of the VaultServer the snapshot was taken from")
+
+	cmd.Flags().StringVar(&opt.backupOptions.Host, "hostname", opt.backupOptions.Host, "Name of the host the snapshot will be stored as")
+	cmd.Flags().StringVar(&opt.appBindingName, "appbinding", opt.appBindingName, "Name of the app binding the snapshot will be recorded for")
+	cmd.Flags().StringVar(&opt.appBindingNamespace, "appbinding-namespace", opt.appBindingNamespace, "Namespace of the app binding")
+	cmd.Flags().StringVar(&opt.keyPrefix, "key-prefix", opt.keyPrefix, "prefix that will be append to root-token & unseal-keys")
+	cmd.Flags().StringVar(&opt.interimDataDir, "interim-data-dir", opt.interimDataDir, "Directory where the snapshot will be prepared before uploading to the backend, must be the same as backup-vault uses")
+	cmd.Flags().BoolVar(&opt.unpackSnapshot, "unpack-snapshot", opt.unpackSnapshot, "Specify whether to store the snapshot entries uncompressed so that restic can deduplicate them across backups")
+	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
+
+	return cmd
+}
+
+// importSnapshot validates the snapshot, prepares the same backup set as backup-vault in the interim directory and uploads it
+func (opt *vaultOptions) importSnapshot(imp importOptions) (*BackupOutput, error) {
+	resticWrapper, err := opt.newRepositoryWrapper()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := clearDir(opt.interimDataDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opt.setupOptions.ScratchDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Validating snapshot %s", imp.snapshotFile)
+	if _, err := validateRaftSnapshot(imp.snapshotFile, opt.setupOptions.ScratchDir); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s. Reason: %w", imp.snapshotFile, err)
+	}
+
+	f, err := os.Create(filepath.Join(opt.interimDataDir, VaultSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	err = copyFile(f, imp.snapshotFile)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := opt.importKeys(imp)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultStats := VaultStats{
+		Hostname:     opt.backupOptions.Host,
+		UnsealMode:   imp.unsealMode,
+		VaultVersion: imp.vaultVersion,
+		KeyCount:     len(keys),
+		AppBinding:   fmt.Sprintf("%s/%s", opt.appBindingNamespace, opt.appBindingName),
+		Reason:       fmt.Sprintf("imported from %s", filepath.Base(imp.snapshotFile)),
+	}
+	if err := vaultStats.setSnapshotInfo(filepath.Join(opt.interimDataDir, VaultSnapshotFile)); err != nil {
+		return nil, err
+	}
+
+	manifest := &vaultManifest{Vault: vaultStats}
+	if err := opt.applySnapshotLayout(manifest); err != nil {
+		return nil, err
+	}
+	if err := opt.writeManifest(manifest); err != nil {
+		return nil, err
+	}
+
+	targetRef := api_v1beta1.TargetRef{
+		APIVersion: appcatalog.SchemeGroupVersion.String(),
+		Kind:       appcatalog.ResourceKindApp,
+		Name:       opt.appBindingName,
+		Namespace:  opt.appBindingNamespace,
+	}
+	opt.backupOptions.BackupPaths = []string{opt.interimDataDir}
+	opt.backupOptions.Args = append(opt.backupOptions.Args, vaultStats.tags()...)
+
+	out, err := resticWrapper.RunBackup(opt.backupOptions, targetRef)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Snapshot %s imported successfully with Raft index %d", imp.snapshotFile, vaultStats.RaftIndex)
+	return &BackupOutput{
+		BackupOutput: *out,
+		VaultStats:   []VaultStats{vaultStats},
+	}, nil
+}
+
+// importKeys writes the given root token & unseal keys into the interim directory with the same names as backup-vault
+func (opt *vaultOptions) importKeys(imp importOptions) ([]string, error) {
+	files := map[string]string{}
+	if imp.rootTokenFile != "" {
+		files[opt.tokenName(opt.keyPrefix)] = imp.rootTokenFile
+	}
+	for i, path := range imp.unsealKeyFiles {
+		files[opt.unsealKeyName(opt.keyPrefix, i)] = path
+	}
+
+	var keys []string
+	for key, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s. Reason: %w", key, err)
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return nil, fmt.Errorf("key file %s is empty", path)
+		}
+
+		if err := opt.write(key, value); err != nil {
+			return nil, fmt.Errorf("failed to write key %s. Reason: %w", key, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
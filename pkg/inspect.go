@@ -0,0 +1,236 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+)
+
+const (
+	OutputFormatTable = "table"
+	OutputFormatJSON  = "json"
+)
+
+// snapshotInfo shows the content of a backed up snapshot
+type snapshotInfo struct {
+	ID       string         `json:"id"`
+	Hostname string         `json:"hostname"`
+	Time     time.Time      `json:"time"`
+	Tags     []string       `json:"tags,omitempty"`
+	Raft     raftInfo       `json:"raft"`
+	Keys     []keyFileInfo  `json:"keys"`
+	Manifest *vaultManifest `json:"manifest,omitempty"`
+}
+
+type raftInfo struct {
+	Index              uint64       `json:"index"`
+	Term               uint64       `json:"term"`
+	Version            int          `json:"version"`
+	Size               int64        `json:"size"`
+	ArchiveSize        int64        `json:"archiveSize,omitempty"`
+	ConfigurationIndex uint64       `json:"configurationIndex"`
+	Peers              []raftServer `json:"peers,omitempty"`
+}
+
+type keyFileInfo struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewCmdInspect() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		snapshotID     string
+		outputFormat   = OutputFormatTable
+
+		opt = vaultOptions{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "inspect",
+		Short:             "Inspects a Vault backup without restoring it",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "snapshot", "provider", "storage-secret-name", "storage-secret-namespace")
+
+			if outputFormat != OutputFormatTable && outputFormat != OutputFormatJSON {
+				return fmt.Errorf("unknown output format %q", outputFormat)
+			}
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+
+			resticWrapper, err := opt.newRepositoryWrapper()
+			if err != nil {
+				return err
+			}
+
+			info, err := opt.inspectSnapshot(resticWrapper, snapshotID)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat == OutputFormatJSON {
+				return printJSON(os.Stdout, info)
+			}
+			return printSnapshotInfo(os.Stdout, info)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	opt.addRepositoryFlags(cmd)
+
+	cmd.Flags().StringVar(&snapshotID, "snapshot", snapshotID, "Snapshot to inspect")
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormat, "Output format (i.e. table, json)")
+
+	return cmd
+}
+
+// inspectSnapshot downloads the snapshot into a temporary directory and reads its content.
+// The downloaded data is removed before returning.
+func (opt *vaultOptions) inspectSnapshot(w *restic.ResticWrapper, snapshotID string) (*snapshotInfo, error) {
+	tmpDir, dir, snapshot, err := opt.fetchBackupSet(w, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readBackupSetMeta(dir, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &snapshotInfo{
+		ID:       snapshot.ID,
+		Hostname: snapshot.Hostname,
+		Time:     snapshot.Time,
+		Tags:     snapshot.Tags,
+		Raft: raftInfo{
+			Index:              meta.Index,
+			Term:               meta.Term,
+			Version:            meta.Version,
+			Size:               meta.Size,
+			ConfigurationIndex: meta.ConfigurationIndex,
+			Peers:              meta.Configuration.Servers,
+		},
+		Manifest: manifest,
+	}
+	if fi, err := os.Stat(filepath.Join(dir, VaultSnapshotFile)); err == nil {
+		info.Raft.ArchiveSize = fi.Size()
+	} else if manifest != nil {
+		info.Raft.ArchiveSize = manifest.Vault.SnapshotSize
+	}
+
+	keys, err := listKeyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		sum, err := fileSHA256(filepath.Join(dir, key))
+		if err != nil {
+			return nil, err
+		}
+		info.Keys = append(info.Keys, keyFileInfo{Name: key, SHA256: sum})
+	}
+
+	return info, nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func printSnapshotInfo(w io.Writer, info *snapshotInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Snapshot:\t%s\n", info.ID)
+	fmt.Fprintf(tw, "Hostname:\t%s\n", info.Hostname)
+	fmt.Fprintf(tw, "Time:\t%s\n", info.Time.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Raft Index:\t%d\n", info.Raft.Index)
+	fmt.Fprintf(tw, "Raft Term:\t%d\n", info.Raft.Term)
+	fmt.Fprintf(tw, "Snapshot Version:\t%d\n", info.Raft.Version)
+	fmt.Fprintf(tw, "Size:\t%d\n", info.Raft.Size)
+	if info.Raft.ArchiveSize != 0 {
+		fmt.Fprintf(tw, "Archive Size:\t%d\n", info.Raft.ArchiveSize)
+	}
+	if info.Manifest != nil {
+		fmt.Fprintf(tw, "Layout:\t%s\n", info.Manifest.Layout)
+		fmt.Fprintf(tw, "Vault Version:\t%s\n", info.Manifest.Vault.VaultVersion)
+		fmt.Fprintf(tw, "Cluster:\t%s (%s)\n", info.Manifest.Vault.ClusterName, info.Manifest.Vault.ClusterID)
+		fmt.Fprintf(tw, "Unseal Mode:\t%s\n", info.Manifest.Vault.UnsealMode)
+	}
+
+	fmt.Fprintf(tw, "\nPEER ID\tADDRESS\tSUFFRAGE\n")
+	for _, peer := range info.Raft.Peers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", peer.ID, peer.Address, suffrageName(peer.Suffrage))
+	}
+
+	fmt.Fprintf(tw, "\nKEY\tSHA256\n")
+	for _, key := range info.Keys {
+		fmt.Fprintf(tw, "%s\t%s\n", key.Name, key.SHA256)
+	}
+
+	if info.Manifest != nil && len(info.Manifest.Entries) != 0 {
+		fmt.Fprintf(tw, "\nSNAPSHOT ENTRY\tSIZE\tSHA256\n")
+		for _, entry := range info.Manifest.Entries {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", entry.Name, entry.Size, entry.SHA256)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func suffrageName(suffrage int) string {
+	switch suffrage {
+	case 0:
+		return "Voter"
+	case 1:
+		return "Nonvoter"
+	case 2:
+		return "Staging"
+	}
+	return fmt.Sprintf("Unknown(%d)", suffrage)
+}
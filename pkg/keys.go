@@ -0,0 +1,168 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	rootTokenPattern = regexp.MustCompile(`^(?:(.+)-)?root-token$`)
+	unsealKeyPattern = regexp.MustCompile(`^-?unseal-key-(\d+)$`)
+)
+
+// discoverVaultKeys finds the prefix of the root token & unseal keys in the store and the number of unseal keys present.
+// The given prefix must have its root token in the store. The prefix is only detected from the stored keys when asked
+// with --detect-key-prefix, as a shared store may hold the keys of other clusters.
+func (opt *vaultOptions) discoverVaultKeys(st store.StoreInterface, keyPrefix string, secretShares int) (string, int, error) {
+	exists, err := st.Exists(opt.tokenName(keyPrefix))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to check key %s. Reason: %w", opt.tokenName(keyPrefix), err)
+	}
+	if !exists {
+		if !opt.detectKeyPrefix {
+			return "", 0, fmt.Errorf("root token %s not found, specify the prefix with --key-prefix or detect it with --detect-key-prefix", opt.tokenName(keyPrefix))
+		}
+		detected, err := detectKeyPrefix(st)
+		if err != nil {
+			return "", 0, fmt.Errorf("root token %s not found. Reason: %w", opt.tokenName(keyPrefix), err)
+		}
+		klog.Warningf("root token %s not found, using the keys with detected prefix %q", opt.tokenName(keyPrefix), detected)
+		keyPrefix = detected
+	}
+
+	ids, err := unsealKeyIDs(st, keyPrefix)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the unseal keys are read back in order until the first missing one, so only count the consecutive keys
+	shares := 0
+	for shares < len(ids) && ids[shares] == shares {
+		shares++
+	}
+	if shares == 0 {
+		return "", 0, fmt.Errorf("no unseal key found with prefix %q", keyPrefix)
+	}
+	if shares != len(ids) {
+		klog.Warningf("ignoring unseal keys with prefix %q after the missing unseal key %d", keyPrefix, shares)
+	}
+	if secretShares != 0 && shares != secretShares {
+		klog.Warningf("found %d unseal keys with prefix %q, but the VaultServer is configured with %d secret shares", shares, keyPrefix, secretShares)
+	}
+
+	return keyPrefix, shares, nil
+}
+
+// detectKeyPrefix returns the prefix of the only root token in the store
+func detectKeyPrefix(st store.StoreInterface) (string, error) {
+	names, err := st.List("")
+	if err != nil {
+		return "", err
+	}
+
+	var prefixes []string
+	for _, name := range names {
+		if m := rootTokenPattern.FindStringSubmatch(name); m != nil {
+			prefixes = append(prefixes, m[1])
+		}
+	}
+
+	switch len(prefixes) {
+	case 0:
+		return "", fmt.Errorf("store does not have any root token")
+	case 1:
+		return prefixes[0], nil
+	}
+	return "", fmt.Errorf("store has root tokens with prefixes %q, specify the prefix with --key-prefix", prefixes)
+}
+
+// unsealKeyIDs returns the sorted ids of the unseal keys with the prefix.
+// Stores may encode the listed names (i.e. azure), so the prefix is cut by length instead of compared.
+// Without a prefix, only the unseal keys are listed, as a store may refuse to list everything (i.e. aws).
+func unsealKeyIDs(st store.StoreInterface, keyPrefix string) ([]int, error) {
+	listPrefix := keyPrefix
+	if listPrefix == "" {
+		listPrefix = "unseal-key-"
+	}
+	names, err := st.List(listPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys with prefix %q. Reason: %w", keyPrefix, err)
+	}
+
+	var ids []int
+	for _, name := range names {
+		if len(name) < len(keyPrefix) {
+			continue
+		}
+		rest := name[len(keyPrefix):]
+		if keyPrefix != "" && !strings.HasPrefix(rest, "-") {
+			continue
+		}
+		m := unsealKeyPattern.FindStringSubmatch(rest)
+		if m == nil {
+			continue
+		}
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// deleteStaleUnsealKeys removes the unseal keys with the prefix left behind by a previous initialization with more secret shares.
+// The keys are copied under a timestamped key first, as deleting may also remove the versions kept by the store.
+func (opt *vaultOptions) deleteStaleUnsealKeys(st store.StoreInterface, keyPrefix string, shares int, now time.Time) error {
+	ids, err := unsealKeyIDs(st, keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id < shares {
+			continue
+		}
+		key := opt.unsealKeyName(keyPrefix, id)
+		value, err := st.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to get stale unseal key %s. Reason: %w", key, err)
+		}
+		archived := store.ArchivedKeyName(key, now)
+		if err := st.Set(archived, value); err != nil {
+			return fmt.Errorf("failed to archive stale unseal key %s. Reason: %w", key, err)
+		}
+
+		klog.Infof("Deleting stale unseal key %s, archived as %s", key, archived)
+		if err := st.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete stale unseal key %s. Reason: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"stash.appscode.dev/vault/pkg/store"
+	"stash.appscode.dev/vault/pkg/store/local"
+)
+
+func newTestLocalStore(t *testing.T, keys ...string) store.StoreInterface {
+	t.Helper()
+	st, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := st.Set(key, "value-of-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st
+}
+
+func TestUnsealKeyIDs(t *testing.T) {
+	cases := []struct {
+		name   string
+		keys   []string
+		prefix string
+		want   []int
+	}{
+		{
+			name:   "with prefix",
+			keys:   []string{"k8s.a-root-token", "k8s.a-unseal-key-1", "k8s.a-unseal-key-0", "k8s.a-unseal-key-10"},
+			prefix: "k8s.a",
+			want:   []int{0, 1, 10},
+		},
+		{
+			name:   "without prefix",
+			keys:   []string{"root-token", "unseal-key-0", "unseal-key-1"},
+			prefix: "",
+			want:   []int{0, 1},
+		},
+		{
+			name:   "longer prefix sharing the start is ignored",
+			keys:   []string{"k8s.a-unseal-key-0", "k8s.ab-unseal-key-0", "k8s.ab-unseal-key-1"},
+			prefix: "k8s.a",
+			want:   []int{0},
+		},
+		{
+			name:   "archived keys are ignored",
+			keys:   []string{"k8s.a-unseal-key-0", "k8s.a-unseal-key-1-archived-20240101T000000Z"},
+			prefix: "k8s.a",
+			want:   []int{0},
+		},
+		{
+			name:   "no keys",
+			prefix: "k8s.a",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := newTestLocalStore(t, c.keys...)
+			got, err := unsealKeyIDs(st, c.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("unsealKeyIDs() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverVaultKeys(t *testing.T) {
+	cases := []struct {
+		name       string
+		keys       []string
+		prefix     string
+		detect     bool
+		wantPrefix string
+		wantShares int
+		wantErr    bool
+	}{
+		{
+			name:       "configured prefix",
+			keys:       []string{"a-root-token", "a-unseal-key-0", "a-unseal-key-1", "b-root-token", "b-unseal-key-0"},
+			prefix:     "a",
+			wantPrefix: "a",
+			wantShares: 2,
+		},
+		{
+			name:       "consecutive shares only",
+			keys:       []string{"a-root-token", "a-unseal-key-0", "a-unseal-key-2"},
+			prefix:     "a",
+			wantPrefix: "a",
+			wantShares: 1,
+		},
+		{
+			// a shared store may hold the keys of another cluster
+			name:    "missing prefix is not detected by default",
+			keys:    []string{"b-root-token", "b-unseal-key-0"},
+			prefix:  "a",
+			wantErr: true,
+		},
+		{
+			name:       "missing prefix detected on request",
+			keys:       []string{"b-root-token", "b-unseal-key-0"},
+			prefix:     "a",
+			detect:     true,
+			wantPrefix: "b",
+			wantShares: 1,
+		},
+		{
+			name:    "ambiguous detection",
+			keys:    []string{"b-root-token", "b-unseal-key-0", "c-root-token", "c-unseal-key-0"},
+			prefix:  "a",
+			detect:  true,
+			wantErr: true,
+		},
+		{
+			name:    "root token without unseal keys",
+			keys:    []string{"a-root-token"},
+			prefix:  "a",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := newTestLocalStore(t, c.keys...)
+			opt := &vaultOptions{detectKeyPrefix: c.detect}
+
+			prefix, shares, err := opt.discoverVaultKeys(st, c.prefix, 0)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("discoverVaultKeys() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if prefix != c.wantPrefix || shares != c.wantShares {
+				t.Errorf("discoverVaultKeys() = %q, %d, want %q, %d", prefix, shares, c.wantPrefix, c.wantShares)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+	"k8s.io/klog/v2"
+)
+
+// backupInfo shows a backed up snapshot along with its Vault specific information
+type backupInfo struct {
+	ID       string     `json:"id"`
+	Hostname string     `json:"hostname"`
+	Time     time.Time  `json:"time"`
+	Vault    VaultStats `json:"vault"`
+
+	snapshot restic.Snapshot
+}
+
+// snapshotFilter selects the snapshots of the repository
+type snapshotFilter struct {
+	// Hostname selects the snapshots of the given host
+	Hostname string
+	// AppBinding selects the snapshots of the given namespace/name of AppBinding
+	AppBinding string
+	// Before selects the snapshots taken before the given time
+	Before *time.Time
+}
+
+func NewCmdListBackups() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		before         string
+		outputFormat   = OutputFormatTable
+		filter         snapshotFilter
+		readManifests  bool
+
+		opt = vaultOptions{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "list-backups",
+		Short:             "Lists the Vault backups of the repository",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "storage-secret-name", "storage-secret-namespace")
+
+			if outputFormat != OutputFormatTable && outputFormat != OutputFormatJSON {
+				return fmt.Errorf("unknown output format %q", outputFormat)
+			}
+
+			if before != "" {
+				t, err := time.Parse(time.RFC3339, before)
+				if err != nil {
+					return fmt.Errorf("invalid timestamp %q for --before. Reason: %w", before, err)
+				}
+				filter.Before = &t
+			}
+			if opt.appBindingName != "" {
+				namespace := opt.appBindingNamespace
+				if namespace == "" {
+					namespace = opt.namespace
+				}
+				filter.AppBinding = fmt.Sprintf("%s/%s", namespace, opt.appBindingName)
+			}
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+
+			resticWrapper, err := opt.newRepositoryWrapper()
+			if err != nil {
+				return err
+			}
+
+			backups, err := listBackups(resticWrapper, filter, readManifests)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat == OutputFormatJSON {
+				return printJSON(os.Stdout, backups)
+			}
+			return printBackups(os.Stdout, backups)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.namespace, "namespace", "default", "Namespace of the app binding if --appbinding-namespace is not specified")
+	opt.addRepositoryFlags(cmd)
+
+	cmd.Flags().StringVar(&filter.Hostname, "hostname", filter.Hostname, "List only the backups of this host")
+	cmd.Flags().StringVar(&opt.appBindingName, "appbinding", opt.appBindingName, "List only the backups of this app binding")
+	cmd.Flags().StringVar(&opt.appBindingNamespace, "appbinding-namespace", opt.appBindingNamespace, "Namespace of the app binding")
+	cmd.Flags().StringVar(&before, "before", before, "List only the backups taken before this RFC3339 timestamp")
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormat, "Output format (i.e. table, json)")
+	cmd.Flags().BoolVar(&readManifests, "read-manifests", readManifests, "Read the Vault information of the backups without tags from their manifest, it runs a restic dump per such backup")
+
+	return cmd
+}
+
+// listBackups returns the snapshots of the repository selected by the filter, newest first.
+// Vault specific information is read from the snapshot tags. Reading the manifest of a snapshot without tags costs
+// a restic dump, so it is only done if readManifests is set, otherwise the information of such snapshot is unknown.
+func listBackups(w *restic.ResticWrapper, filter snapshotFilter, readManifests bool) ([]backupInfo, error) {
+	snapshots, err := w.ListSnapshots(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots. Reason: %w", err)
+	}
+
+	var backups []backupInfo
+	for _, snapshot := range snapshots {
+		if filter.Hostname != "" && snapshot.Hostname != filter.Hostname {
+			continue
+		}
+		if filter.Before != nil && !snapshot.Time.Before(*filter.Before) {
+			continue
+		}
+
+		b := backupInfo{
+			ID:       snapshot.ID,
+			Hostname: snapshot.Hostname,
+			Time:     snapshot.Time,
+			Vault:    vaultStatsFromTags(snapshot.Hostname, snapshot.Tags),
+			snapshot: snapshot,
+		}
+		b.Vault.SnapshotID = snapshot.ID
+		if readManifests && b.Vault.RaftIndex == 0 {
+			b.readManifest(w)
+		}
+
+		if filter.AppBinding != "" && b.Vault.AppBinding != filter.AppBinding {
+			continue
+		}
+
+		backups = append(backups, b)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// readManifest replaces the Vault information of a snapshot without tags with the one recorded in its manifest
+func (b *backupInfo) readManifest(w *restic.ResticWrapper) {
+	manifest, err := dumpManifest(w, b.snapshot)
+	if err != nil {
+		klog.Warningf("failed to read manifest of snapshot %s. Reason: %v", b.ID, err)
+		return
+	}
+	if manifest != nil {
+		b.Vault = manifest.Vault
+		b.Vault.Hostname = b.Hostname
+		b.Vault.SnapshotID = b.ID
+	}
+}
+
+// dumpManifest reads the manifest of the snapshot without restoring the whole snapshot.
+// It returns nil if the snapshot does not have any manifest.
+func dumpManifest(w *restic.ResticWrapper, snapshot restic.Snapshot) (*vaultManifest, error) {
+	if len(snapshot.Paths) != 1 {
+		return nil, nil
+	}
+
+	data, err := w.DumpOnce(restic.DumpOptions{
+		Snapshot: snapshot.ID,
+		FileName: filepath.Join(snapshot.Paths[0], VaultManifestFile),
+	})
+	if err != nil {
+		// snapshots taken before manifest was introduced don't have any manifest
+		klog.V(4).Infof("snapshot %s has no manifest. Reason: %v", snapshot.ID, err)
+		return nil, nil
+	}
+
+	manifest := &vaultManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func printBackups(w io.Writer, backups []backupInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID\tTIME\tHOSTNAME\tAPPBINDING\tRAFT INDEX\tVAULT VERSION\tKEYS\n")
+	for _, b := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			shortID(b.ID), b.Time.Format(time.RFC3339), b.Hostname, valueOrNone(b.Vault.AppBinding),
+			valueOrNone(formatUint(b.Vault.RaftIndex)), valueOrNone(b.Vault.VaultVersion), valueOrNone(formatUint(uint64(b.Vault.KeyCount))))
+	}
+
+	return tw.Flush()
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func valueOrNone(v string) string {
+	if v == "" {
+		return "<none>"
+	}
+	return v
+}
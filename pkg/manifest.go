@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	VaultManifestFile = "manifest.json"
+	VaultSnapshotDir  = "snapshot"
+
+	// SnapshotLayoutArchive stores the snapshot as the gzip compressed archive saved by Vault
+	SnapshotLayoutArchive = "Archive"
+	// SnapshotLayoutUnpacked stores the entries of the snapshot archive uncompressed, so that restic can deduplicate them
+	SnapshotLayoutUnpacked = "Unpacked"
+)
+
+// vaultManifest describes the content of a backup set
+type vaultManifest struct {
+	// Layout indicates how the Raft snapshot is stored in the backup set
+	Layout string `json:"layout"`
+	// Entries lists the snapshot archive entries when the snapshot is stored unpacked
+	Entries []snapshotEntry `json:"entries,omitempty"`
+	// Vault shows the Vault specific information of the snapshot
+	Vault VaultStats `json:"vault"`
+}
+
+func (opt *vaultOptions) writeManifest(manifest *vaultManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(opt.interimDataDir, VaultManifestFile), data, 0o644)
+}
+
+// readManifest reads the manifest of the backup set stored in dir. It returns nil if the backup set was taken before manifest was introduced.
+func readManifest(dir string) (*vaultManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, VaultManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifest := &vaultManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode %s. Reason: %w", VaultManifestFile, err)
+	}
+	return manifest, nil
+}
+
+// applySnapshotLayout unpacks the snapshot archive in the interim directory when the unpack option is set
+func (opt *vaultOptions) applySnapshotLayout(manifest *vaultManifest) error {
+	manifest.Layout = SnapshotLayoutArchive
+	if !opt.unpackSnapshot {
+		return nil
+	}
+
+	klog.Infoln("Unpacking snapshot")
+	snapPath := filepath.Join(opt.interimDataDir, VaultSnapshotFile)
+	entries, err := unpackSnapshot(snapPath, filepath.Join(opt.interimDataDir, VaultSnapshotDir))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(snapPath); err != nil {
+		return err
+	}
+
+	manifest.Layout = SnapshotLayoutUnpacked
+	manifest.Entries = entries
+	return nil
+}
+
+// prepareVaultSnapshot reassembles the snapshot archive in the interim directory if it was backed up unpacked
+func (opt *vaultOptions) prepareVaultSnapshot() (*vaultManifest, error) {
+	manifest, err := readManifest(opt.interimDataDir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil || manifest.Layout != SnapshotLayoutUnpacked {
+		return manifest, nil
+	}
+
+	klog.Infoln("Reassembling snapshot from unpacked entries")
+	if err := packSnapshot(filepath.Join(opt.interimDataDir, VaultSnapshotDir), manifest.Entries, filepath.Join(opt.interimDataDir, VaultSnapshotFile)); err != nil {
+		return nil, fmt.Errorf("failed to reassemble snapshot. Reason: %w", err)
+	}
+	return manifest, nil
+}
+
+// readBackupSetMeta reads the Raft metadata of the snapshot of the backup set stored in dir without reassembling the snapshot
+func readBackupSetMeta(dir string, manifest *vaultManifest) (*raftSnapshotMeta, error) {
+	if manifest == nil || manifest.Layout != SnapshotLayoutUnpacked {
+		return readRaftSnapshotMeta(filepath.Join(dir, VaultSnapshotFile))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, VaultSnapshotDir, RaftSnapshotMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	meta := &raftSnapshotMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("failed to decode %s. Reason: %w", RaftSnapshotMetaFile, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,257 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	"github.com/spf13/cobra"
+	license "go.bytebuilders.dev/license-verifier/kubernetes"
+	"gomodules.xyz/flags"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultconfig "kubevault.dev/apimachinery/apis/config/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+	"sigs.k8s.io/yaml"
+)
+
+// migrateOptions holds the source & destination of the unseal keys & root token
+type migrateOptions struct {
+	sourceModeFile      string
+	destinationModeFile string
+	newKeyPrefix        string
+	overwriteExisting   bool
+	deleteSource        bool
+}
+
+func NewCmdMigrateStore() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		mig            migrateOptions
+		opt            vaultOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:               "migrate-store",
+		Short:             "Migrates the unseal keys & root token to another unseal mode or KMS key",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "appbinding", "appbinding-namespace", "destination-mode-file")
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+			if err := license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts); err != nil {
+				return err
+			}
+
+			appBinding, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).Get(context.TODO(), opt.appBindingName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			parameters := vaultconfig.VaultServerConfiguration{}
+			if appBinding.Spec.Parameters != nil {
+				if err = json.Unmarshal(appBinding.Spec.Parameters.Raw, &parameters); err != nil {
+					return fmt.Errorf("unable to unmarshal appBinding.Spec.Parameters.Raw: %w", err)
+				}
+			}
+
+			return opt.migrateStore(appBinding, parameters, mig)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+
+	cmd.Flags().StringVar(&opt.appBindingName, "appbinding", opt.appBindingName, "Name of the app binding of the VaultServer")
+	cmd.Flags().StringVar(&opt.appBindingNamespace, "appbinding-namespace", opt.appBindingNamespace, "Namespace of the app binding")
+	cmd.Flags().StringVar(&opt.keyPrefix, "key-prefix", opt.keyPrefix, "prefix of the root-token & unseal-keys in the source store")
+	cmd.Flags().BoolVar(&opt.detectKeyPrefix, "detect-key-prefix", opt.detectKeyPrefix, "Specify whether to use the prefix of the only root-token in the source store if the root-token of --key-prefix is not found")
+	cmd.Flags().StringVar(&mig.newKeyPrefix, "new-key-prefix", mig.newKeyPrefix, "prefix of the root-token & unseal-keys in the destination store (defaults to the source prefix)")
+	cmd.Flags().StringVar(&mig.sourceModeFile, "source-mode-file", mig.sourceModeFile, "Path of the YAML/JSON unsealer mode of the source store (defaults to the unsealer mode of the app binding)")
+	cmd.Flags().StringVar(&mig.destinationModeFile, "destination-mode-file", mig.destinationModeFile, "Path of the YAML/JSON unsealer mode of the destination store")
+	cmd.Flags().BoolVar(&mig.overwriteExisting, "overwrite-existing", mig.overwriteExisting, "Specify whether to overwrite different keys in the destination store after archiving them (implied by overwriteExisting of the unsealer spec)")
+	cmd.Flags().BoolVar(&mig.deleteSource, "delete-source", mig.deleteSource, "Specify whether to delete the keys from the source store once the destination has been verified")
+
+	return cmd
+}
+
+// migrateStore copies the root token & unseal keys from the source store into the destination store and verifies them by reading them back
+func (opt *vaultOptions) migrateStore(appBinding *appcatalog.AppBinding, params vaultconfig.VaultServerConfiguration, mig migrateOptions) error {
+	var srcMode vaultapi.ModeSpec
+	var secretShares int
+	if params.Unsealer != nil {
+		srcMode = params.Unsealer.Mode
+		secretShares = int(params.Unsealer.SecretShares)
+		mig.overwriteExisting = mig.overwriteExisting || params.Unsealer.OverwriteExisting
+	}
+	if mig.sourceModeFile != "" {
+		mode, err := readModeSpec(mig.sourceModeFile)
+		if err != nil {
+			return err
+		}
+		srcMode = *mode
+	}
+	dstMode, err := readModeSpec(mig.destinationModeFile)
+	if err != nil {
+		return err
+	}
+
+	src, err := store.NewStore(opt.kubeClient, appBinding, &vaultapi.UnsealerSpec{Mode: srcMode})
+	if err != nil {
+		return fmt.Errorf("failed to create source store. Reason: %w", err)
+	}
+	defer closeStore(src)
+	dst, err := store.NewStore(opt.kubeClient, appBinding, &vaultapi.UnsealerSpec{Mode: *dstMode})
+	if err != nil {
+		return fmt.Errorf("failed to create destination store. Reason: %w", err)
+	}
+	defer closeStore(dst)
+
+	srcPrefix, shares, err := opt.discoverVaultKeys(src, opt.keyPrefix, secretShares)
+	if err != nil {
+		return err
+	}
+	dstPrefix := mig.newKeyPrefix
+	if dstPrefix == "" {
+		dstPrefix = srcPrefix
+	}
+
+	srcKeys := []string{opt.tokenName(srcPrefix)}
+	dstKeys := []string{opt.tokenName(dstPrefix)}
+	for i := 0; i < shares; i++ {
+		srcKeys = append(srcKeys, opt.unsealKeyName(srcPrefix, i))
+		dstKeys = append(dstKeys, opt.unsealKeyName(dstPrefix, i))
+	}
+
+	values := map[string]string{}
+	for idx, key := range srcKeys {
+		value, err := src.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to get key %s from the source store. Reason: %w", key, err)
+		}
+		values[dstKeys[idx]] = value
+	}
+
+	// rewrapping in place with another KMS key overwrites the source keys, which the destination can't decrypt yet
+	inPlace := sameStoreEntries(srcMode, *dstMode) && srcPrefix == dstPrefix
+	now := time.Now()
+	if inPlace {
+		for _, key := range srcKeys {
+			rollback, err := store.Archive(src, key, now)
+			if err != nil {
+				return fmt.Errorf("failed to archive key %s before rewrapping it. Reason: %w", key, err)
+			}
+			klog.Warningf("Rewrapping key %s: %s", key, rollback)
+		}
+	} else {
+		overwrites, err := existingKeys(dst, values)
+		if err != nil {
+			return err
+		}
+		if len(overwrites) > 0 && !mig.overwriteExisting {
+			return fmt.Errorf("keys %q already exist in the destination store with different values, use --overwrite-existing to overwrite them", overwrites)
+		}
+		for _, key := range overwrites {
+			rollback, err := store.Archive(dst, key, now)
+			if err != nil {
+				return fmt.Errorf("failed to archive key %s before overwriting it. Reason: %w", key, err)
+			}
+			klog.Warningf("Overwriting key %s: %s", key, rollback)
+		}
+	}
+
+	for _, key := range dstKeys {
+		if err := dst.Set(key, values[key]); err != nil {
+			return fmt.Errorf("failed to set key %s in the destination store. Reason: %w", key, err)
+		}
+	}
+
+	// read every key back, so that a destination KMS key that can't decrypt is found before the source is touched
+	for _, key := range dstKeys {
+		value, err := dst.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to verify key %s in the destination store. Reason: %w", key, err)
+		}
+		if value != values[key] {
+			return fmt.Errorf("failed to verify key %s in the destination store. Reason: value mismatch", key)
+		}
+	}
+	klog.Infof("Successfully migrated root token & %d unseal keys from %s mode to %s mode", shares, modeName(srcMode), modeName(*dstMode))
+
+	if mig.deleteSource {
+		if inPlace {
+			klog.Warningln("Keeping the source keys as the destination keys are the same entries, rewrapped in place")
+		} else {
+			for _, key := range srcKeys {
+				if err := src.Delete(key); err != nil {
+					return fmt.Errorf("failed to delete key %s from the source store. Reason: %w", key, err)
+				}
+			}
+			klog.Infoln("Deleted the keys from the source store")
+		}
+	}
+
+	klog.Infof("Update spec.unsealer.mode of the VaultServer of app binding %s/%s to the destination mode", appBinding.Namespace, appBinding.Name)
+	return nil
+}
+
+func readModeSpec(path string) (*vaultapi.ModeSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := &vaultapi.ModeSpec{}
+	if err := yaml.Unmarshal(data, mode); err != nil {
+		return nil, fmt.Errorf("failed to decode unsealer mode %s. Reason: %w", path, err)
+	}
+	if modeName(*mode) == "" {
+		return nil, fmt.Errorf("unsealer mode %s does not specify any mode", path)
+	}
+	return mode, nil
+}
+
+func modeName(mode vaultapi.ModeSpec) string {
+	return unsealMode(&vaultapi.UnsealerSpec{Mode: mode})
+}
+
+// sameStoreEntries reports whether both modes keep the keys at the same place, i.e. only the KMS key differs
+func sameStoreEntries(src, dst vaultapi.ModeSpec) bool {
+	switch {
+	case src.KubernetesSecret != nil && dst.KubernetesSecret != nil:
+		return src.KubernetesSecret.SecretName == dst.KubernetesSecret.SecretName
+	case src.AwsKmsSsm != nil && dst.AwsKmsSsm != nil:
+		return src.AwsKmsSsm.Region == dst.AwsKmsSsm.Region &&
+			src.AwsKmsSsm.Endpoint == dst.AwsKmsSsm.Endpoint &&
+			src.AwsKmsSsm.SsmKeyPrefix == dst.AwsKmsSsm.SsmKeyPrefix
+	case src.GoogleKmsGcs != nil && dst.GoogleKmsGcs != nil:
+		return src.GoogleKmsGcs.Bucket == dst.GoogleKmsGcs.Bucket
+	case src.AzureKeyVault != nil && dst.AzureKeyVault != nil:
+		return src.AzureKeyVault.VaultBaseURL == dst.AzureKeyVault.VaultBaseURL
+	}
+	return false
+}
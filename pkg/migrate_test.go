@@ -0,0 +1,66 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"testing"
+
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+func TestSameStoreEntries(t *testing.T) {
+	k8sSecret := func(name string) vaultapi.ModeSpec {
+		return vaultapi.ModeSpec{KubernetesSecret: &vaultapi.KubernetesSecretSpec{SecretName: name}}
+	}
+	awsSsm := func(region, prefix string) vaultapi.ModeSpec {
+		return vaultapi.ModeSpec{AwsKmsSsm: &vaultapi.AwsKmsSsmSpec{Region: region, SsmKeyPrefix: prefix}}
+	}
+	gcs := func(bucket, key string) vaultapi.ModeSpec {
+		return vaultapi.ModeSpec{GoogleKmsGcs: &vaultapi.GoogleKmsGcsSpec{Bucket: bucket, KmsCryptoKey: key}}
+	}
+	azure := func(url string) vaultapi.ModeSpec {
+		return vaultapi.ModeSpec{AzureKeyVault: &vaultapi.AzureKeyVault{VaultBaseURL: url}}
+	}
+
+	cases := []struct {
+		name string
+		src  vaultapi.ModeSpec
+		dst  vaultapi.ModeSpec
+		want bool
+	}{
+		{name: "same kubernetes secret", src: k8sSecret("keys"), dst: k8sSecret("keys"), want: true},
+		{name: "other kubernetes secret", src: k8sSecret("keys"), dst: k8sSecret("other")},
+		{name: "same ssm parameters", src: awsSsm("us-east-1", ""), dst: awsSsm("us-east-1", ""), want: true},
+		{name: "other ssm region", src: awsSsm("us-east-1", ""), dst: awsSsm("eu-west-1", "")},
+		{name: "other ssm prefix", src: awsSsm("us-east-1", ""), dst: awsSsm("us-east-1", "prod")},
+		// rewrapping with another kms key keeps the objects in the same bucket
+		{name: "same gcs bucket with another kms key", src: gcs("keys", "a"), dst: gcs("keys", "b"), want: true},
+		{name: "other gcs bucket", src: gcs("keys", "a"), dst: gcs("other", "a")},
+		{name: "same key vault", src: azure("https://a.vault.azure.net"), dst: azure("https://a.vault.azure.net"), want: true},
+		{name: "other key vault", src: azure("https://a.vault.azure.net"), dst: azure("https://b.vault.azure.net")},
+		{name: "different modes", src: k8sSecret("keys"), dst: gcs("keys", "a")},
+		{name: "empty modes", src: vaultapi.ModeSpec{}, dst: vaultapi.ModeSpec{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := sameStoreEntries(c.src, c.dst); got != c.want {
+				t.Errorf("sameStoreEntries() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/hashicorp/vault/api"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+// tag keys used to record the Vault specific information in the restic snapshot
+const (
+	TagRaftIndex    = "vault-raft-index"
+	TagRaftTerm     = "vault-raft-term"
+	TagSnapshotSize = "vault-snapshot-size"
+	TagVaultVersion = "vault-version"
+	TagClusterName  = "vault-cluster-name"
+	TagClusterID    = "vault-cluster-id"
+	TagLeader       = "vault-leader"
+	TagUnsealMode   = "vault-unseal-mode"
+	TagKeyCount     = "vault-key-count"
+	TagAppBinding   = "vault-appbinding"
+)
+
+// VaultStats shows the Vault specific information of a backed up or restored snapshot
+type VaultStats struct {
+	// Hostname indicates the restic host this information belongs to
+	Hostname string `json:"hostname,omitempty"`
+	// AppBinding indicates the namespace/name of the AppBinding of the VaultServer
+	AppBinding string `json:"appBinding,omitempty"`
+	// RaftIndex indicates the Raft index of the snapshot
+	RaftIndex uint64 `json:"raftIndex,omitempty"`
+	// RaftTerm indicates the Raft term of the snapshot
+	RaftTerm uint64 `json:"raftTerm,omitempty"`
+	// SnapshotSize indicates the size of the Raft snapshot in bytes
+	SnapshotSize int64 `json:"snapshotSize,omitempty"`
+	// VaultVersion indicates the version of the VaultServer
+	VaultVersion string `json:"vaultVersion,omitempty"`
+	// ClusterName indicates the name of the Vault cluster
+	ClusterName string `json:"clusterName,omitempty"`
+	// ClusterID indicates the ID of the Vault cluster
+	ClusterID string `json:"clusterID,omitempty"`
+	// Leader indicates the address of the leader node the snapshot was taken from
+	Leader string `json:"leader,omitempty"`
+	// UnsealMode indicates the unseal mode of the VaultServer
+	UnsealMode string `json:"unsealMode,omitempty"`
+	// KeyCount indicates the number of unseal keys & root token captured in the snapshot
+	KeyCount int `json:"keyCount,omitempty"`
+	// SnapshotID indicates the restic snapshot this information was read from
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// MissingPeers lists the Raft peers of the backed up cluster that don't exist in the restored cluster
+	MissingPeers []string `json:"missingPeers,omitempty"`
+	// RemovedPeers lists the stale Raft peers removed from the restored cluster
+	RemovedPeers []string `json:"removedPeers,omitempty"`
+	// StoreDivergence lists the differences between the copies of the unseal keys & root token in the mirrored stores
+	StoreDivergence []string `json:"storeDivergence,omitempty"`
+	// Reason indicates why the backup of this host was skipped, or why the snapshot was selected for restore
+	Reason string `json:"reason,omitempty"`
+}
+
+type BackupOutput struct {
+	restic.BackupOutput `json:",inline"`
+	// VaultStats shows the Vault specific information of the individual hosts
+	VaultStats []VaultStats `json:"vaultStats,omitempty"`
+}
+
+type RestoreOutput struct {
+	restic.RestoreOutput `json:",inline"`
+	// VaultStats shows the Vault specific information of the individual hosts
+	VaultStats []VaultStats `json:"vaultStats,omitempty"`
+}
+
+// WriteOutput write output of backup process into output.json file in the directory
+// specified by outputDir parameter
+func (out *BackupOutput) WriteOutput(fileName string) error {
+	return writeOutput(fileName, out)
+}
+
+// WriteOutput write output of restore process into output.json file in the directory
+// specified by outputDir parameter
+func (out *RestoreOutput) WriteOutput(fileName string) error {
+	return writeOutput(fileName, out)
+}
+
+func writeOutput(fileName string, out interface{}) error {
+	jsonOutput, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), restic.FileModeRWXAll); err != nil {
+		return err
+	}
+	// check if the output file already exist. if it does not, then owner should chmod to make the file writable to other users
+	newFile := false
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		newFile = true
+	}
+
+	if err := os.WriteFile(fileName, jsonOutput, restic.FileModeRWXAll); err != nil {
+		return err
+	}
+	// change the file permission to make it writable to other users
+	if newFile {
+		return os.Chmod(fileName, restic.FileModeRWXAll)
+	}
+	return nil
+}
+
+// tags returns the restic backup arguments that record the stats as snapshot tags
+func (stats VaultStats) tags() []string {
+	values := []struct {
+		key   string
+		value string
+	}{
+		{TagRaftIndex, formatUint(stats.RaftIndex)},
+		{TagRaftTerm, formatUint(stats.RaftTerm)},
+		{TagSnapshotSize, formatUint(uint64(stats.SnapshotSize))},
+		{TagVaultVersion, stats.VaultVersion},
+		{TagClusterName, stats.ClusterName},
+		{TagClusterID, stats.ClusterID},
+		{TagLeader, stats.Leader},
+		{TagUnsealMode, stats.UnsealMode},
+		{TagKeyCount, formatUint(uint64(stats.KeyCount))},
+		{TagAppBinding, stats.AppBinding},
+	}
+
+	var args []string
+	for _, v := range values {
+		if v.value == "" {
+			continue
+		}
+		// restic treats comma as the separator of multiple tags
+		args = append(args, "--tag", fmt.Sprintf("%s=%s", v.key, strings.ReplaceAll(v.value, ",", "_")))
+	}
+	return args
+}
+
+// vaultStatsFromTags parses the Vault specific information recorded in the restic snapshot tags
+func vaultStatsFromTags(hostname string, tags []string) VaultStats {
+	stats := VaultStats{
+		Hostname: hostname,
+	}
+	for _, tag := range tags {
+		key, value, found := strings.Cut(tag, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case TagRaftIndex:
+			stats.RaftIndex, _ = strconv.ParseUint(value, 10, 64)
+		case TagRaftTerm:
+			stats.RaftTerm, _ = strconv.ParseUint(value, 10, 64)
+		case TagSnapshotSize:
+			stats.SnapshotSize, _ = strconv.ParseInt(value, 10, 64)
+		case TagVaultVersion:
+			stats.VaultVersion = value
+		case TagClusterName:
+			stats.ClusterName = value
+		case TagClusterID:
+			stats.ClusterID = value
+		case TagLeader:
+			stats.Leader = value
+		case TagUnsealMode:
+			stats.UnsealMode = value
+		case TagKeyCount:
+			stats.KeyCount, _ = strconv.Atoi(value)
+		case TagAppBinding:
+			stats.AppBinding = value
+		}
+	}
+	return stats
+}
+
+// formatUint formats the value as tag value, zero means the value is unknown
+func formatUint(v uint64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// setSnapshotInfo sets the Raft information of the snapshot saved in the given path
+func (stats *VaultStats) setSnapshotInfo(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stats.SnapshotSize = fi.Size()
+
+	meta, err := readRaftSnapshotMeta(path)
+	if err != nil {
+		return err
+	}
+	stats.RaftIndex = meta.Index
+	stats.RaftTerm = meta.Term
+
+	return nil
+}
+
+// setClusterInfo sets the version & cluster information reported by the VaultServer
+func (stats *VaultStats) setClusterInfo(vc *api.Client) error {
+	health, err := vc.Sys().Health()
+	if err != nil {
+		return err
+	}
+	stats.VaultVersion = health.Version
+	stats.ClusterName = health.ClusterName
+	stats.ClusterID = health.ClusterID
+
+	return nil
+}
+
+func unsealMode(unsealer *vaultapi.UnsealerSpec) string {
+	if unsealer == nil {
+		return ""
+	}
+
+	mode := unsealer.Mode
+	switch true {
+	case mode.GoogleKmsGcs != nil:
+		return "googleKmsGcs"
+	case mode.AwsKmsSsm != nil:
+		return "awsKmsSsm"
+	case mode.AzureKeyVault != nil:
+		return "azureKeyVault"
+	case mode.KubernetesSecret != nil:
+		return "kubernetesSecret"
+	}
+	return ""
+}
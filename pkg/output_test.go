@@ -0,0 +1,111 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVaultStatsFromTags(t *testing.T) {
+	cases := []struct {
+		name string
+		tags []string
+		want VaultStats
+	}{
+		{
+			name: "all tags",
+			tags: []string{
+				"vault-raft-index=42", "vault-raft-term=3", "vault-snapshot-size=1024", "vault-version=1.15.0",
+				"vault-cluster-name=vault-cluster-1", "vault-cluster-id=abc", "vault-leader=vault-0",
+				"vault-unseal-mode=kubernetesSecret", "vault-key-count=5", "vault-appbinding=demo/vault",
+			},
+			want: VaultStats{
+				Hostname:     "host-0",
+				RaftIndex:    42,
+				RaftTerm:     3,
+				SnapshotSize: 1024,
+				VaultVersion: "1.15.0",
+				ClusterName:  "vault-cluster-1",
+				ClusterID:    "abc",
+				Leader:       "vault-0",
+				UnsealMode:   "kubernetesSecret",
+				KeyCount:     5,
+				AppBinding:   "demo/vault",
+			},
+		},
+		{
+			name: "tags of other tools are ignored",
+			tags: []string{"manual", "owner=ops", "vault-raft-index=7"},
+			want: VaultStats{Hostname: "host-0", RaftIndex: 7},
+		},
+		{
+			name: "invalid numbers are left unknown",
+			tags: []string{"vault-raft-index=seven", "vault-key-count=-x"},
+			want: VaultStats{Hostname: "host-0"},
+		},
+		{
+			name: "value with an equal sign",
+			tags: []string{"vault-cluster-name=a=b"},
+			want: VaultStats{Hostname: "host-0", ClusterName: "a=b"},
+		},
+		{
+			name: "no tags",
+			want: VaultStats{Hostname: "host-0"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := vaultStatsFromTags("host-0", c.tags)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("vaultStatsFromTags() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestVaultStatsTagsRoundTrip(t *testing.T) {
+	stats := VaultStats{
+		Hostname:     "host-0",
+		RaftIndex:    42,
+		RaftTerm:     3,
+		SnapshotSize: 1024,
+		VaultVersion: "1.15.0",
+		ClusterID:    "abc",
+		UnsealMode:   "awsKmsSsm",
+		KeyCount:     5,
+		AppBinding:   "demo/vault",
+	}
+
+	var tags []string
+	for _, arg := range stats.tags() {
+		if arg != "--tag" {
+			tags = append(tags, arg)
+		}
+	}
+	if got := vaultStatsFromTags(stats.Hostname, tags); !reflect.DeepEqual(got, stats) {
+		t.Errorf("vaultStatsFromTags(tags()) = %+v, want %+v", got, stats)
+	}
+
+	// restic splits the tags on comma
+	stats = VaultStats{ClusterName: "a,b"}
+	if args := strings.Join(stats.tags(), " "); args != "--tag vault-cluster-name=a_b" {
+		t.Errorf("tags() = %q, want the comma replaced", args)
+	}
+}
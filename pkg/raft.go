@@ -0,0 +1,298 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+const (
+	VaultRaftConfigurationFile      = "raft-configuration.json"
+	VaultAutopilotConfigurationFile = "autopilot-configuration.json"
+
+	raftConfigurationPath = "sys/storage/raft/configuration"
+	raftRemovePeerPath    = "sys/storage/raft/remove-peer"
+)
+
+// raftPeerConfiguration is the response of sys/storage/raft/configuration
+type raftPeerConfiguration struct {
+	Servers []raftPeer `json:"servers"`
+	Index   uint64     `json:"index"`
+}
+
+type raftPeer struct {
+	NodeID          string `json:"node_id"`
+	Address         string `json:"address"`
+	Leader          bool   `json:"leader"`
+	ProtocolVersion string `json:"protocol_version"`
+	Voter           bool   `json:"voter"`
+}
+
+// readRaftPeerConfiguration reads the Raft peer configuration of the cluster
+func readRaftPeerConfiguration(vc *api.Client) (*raftPeerConfiguration, error) {
+	secret, err := vc.Logical().Read(raftConfigurationPath)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty response from %s", raftConfigurationPath)
+	}
+
+	data, err := json.Marshal(secret.Data["config"])
+	if err != nil {
+		return nil, err
+	}
+	config := &raftPeerConfiguration{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode Raft configuration. Reason: %w", err)
+	}
+	return config, nil
+}
+
+// writeRaftConfiguration saves the Raft peer configuration & the autopilot configuration of the cluster in the interim directory
+func (opt *vaultOptions) writeRaftConfiguration(vc *api.Client) error {
+	klog.Infoln("Trying to save Raft peer & autopilot configuration")
+
+	peers, err := readRaftPeerConfiguration(vc)
+	if err != nil {
+		return fmt.Errorf("failed to read Raft peer configuration. Reason: %w", err)
+	}
+	if err := writeJSONFile(filepath.Join(opt.interimDataDir, VaultRaftConfigurationFile), peers); err != nil {
+		return err
+	}
+
+	autopilot, err := vc.Sys().RaftAutopilotConfiguration()
+	if err != nil {
+		return fmt.Errorf("failed to read autopilot configuration. Reason: %w", err)
+	}
+	if autopilot == nil {
+		return nil
+	}
+	return writeJSONFile(filepath.Join(opt.interimDataDir, VaultAutopilotConfigurationFile), autopilot)
+}
+
+// restoreRaftConfiguration reapplies the backed up autopilot configuration, and returns the
+// node IDs of the backed up peers that don't exist in the target cluster.
+func (opt *vaultOptions) restoreRaftConfiguration(vc *api.Client) ([]string, error) {
+	autopilotFile := filepath.Join(opt.interimDataDir, VaultAutopilotConfigurationFile)
+	if _, err := os.Stat(autopilotFile); err == nil {
+		autopilot := &api.AutopilotConfig{}
+		if err := readJSONFile(autopilotFile, autopilot); err != nil {
+			return nil, err
+		}
+		if err := vc.Sys().PutRaftAutopilotConfiguration(autopilot); err != nil {
+			return nil, fmt.Errorf("failed to apply autopilot configuration. Reason: %w", err)
+		}
+		klog.Infoln("autopilot configuration restored successfully")
+	}
+
+	peersFile := filepath.Join(opt.interimDataDir, VaultRaftConfigurationFile)
+	if _, err := os.Stat(peersFile); err != nil {
+		// backed up before Raft configuration was captured
+		return nil, nil
+	}
+	source := &raftPeerConfiguration{}
+	if err := readJSONFile(peersFile, source); err != nil {
+		return nil, err
+	}
+
+	target, err := readRaftPeerConfiguration(vc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Raft peer configuration of the target. Reason: %w", err)
+	}
+
+	existing := map[string]bool{}
+	for _, peer := range target.Servers {
+		existing[peer.NodeID] = true
+	}
+
+	var missing []string
+	for _, peer := range source.Servers {
+		if !existing[peer.NodeID] {
+			missing = append(missing, peer.NodeID)
+		}
+	}
+	if len(missing) != 0 {
+		klog.Warningf("Raft peers %v of the backed up cluster don't exist in the target cluster", missing)
+	}
+	return missing, nil
+}
+
+// postRestoreClient returns a client to talk to the VaultServer after the snapshot has been restored.
+// A forcefully restored snapshot replaces the tokens of the cluster, so the restored root token is preferred if it was backed up.
+func (opt *vaultOptions) postRestoreClient(vc *api.Client, session *sessionWrapper, forced bool) (*api.Client, error) {
+	client, err := vc.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	token := session.sh.Env[EnvVaultToken]
+	if forced {
+		if rootToken, err := opt.read(opt.tokenName(opt.oldKeyPrefix)); err == nil && rootToken != "" {
+			token = rootToken
+		}
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// reconcileRaftPeers removes the Raft peers that don't belong to any of the pods behind the service of the AppBinding,
+// then waits for autopilot to report the cluster healthy. It returns the node IDs of the removed peers.
+func (opt *vaultOptions) reconcileRaftPeers(vc *api.Client, appBinding *appcatalog.AppBinding) ([]string, error) {
+	klog.Infoln("Trying to remove stale Raft peers")
+
+	podList, err := opt.vaultPods(appBinding)
+	if err != nil {
+		return nil, err
+	}
+	if len(podList) == 0 {
+		// never remove every peer of the cluster because of a wrong service selector
+		return nil, fmt.Errorf("no pod found behind the service of AppBinding %s/%s", appBinding.Namespace, appBinding.Name)
+	}
+
+	pods := map[string]bool{}
+	for _, pod := range podList {
+		pods[pod.Name] = true
+	}
+
+	config, err := readRaftPeerConfiguration(vc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Raft peer configuration. Reason: %w", err)
+	}
+
+	var removed []string
+	for _, peer := range config.Servers {
+		if peer.Leader || pods[peer.NodeID] || pods[peerHost(peer.Address)] {
+			continue
+		}
+
+		klog.Infof("Removing stale Raft peer %s (%s)", peer.NodeID, peer.Address)
+		if _, err := vc.Logical().Write(raftRemovePeerPath, map[string]interface{}{
+			"server_id": peer.NodeID,
+		}); err != nil {
+			return removed, fmt.Errorf("failed to remove Raft peer %s. Reason: %w", peer.NodeID, err)
+		}
+		removed = append(removed, peer.NodeID)
+	}
+
+	if err := waitForAutopilotHealthy(vc, opt.waitTimeout); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// vaultPods returns the pods selected by the service of the AppBinding, sorted by name
+func (opt *vaultOptions) vaultPods(appBinding *appcatalog.AppBinding) ([]core.Pod, error) {
+	ref := appBinding.Spec.ClientConfig.Service
+	if ref == nil {
+		return nil, fmt.Errorf("AppBinding %s/%s does not refer to any service", appBinding.Namespace, appBinding.Name)
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = appBinding.Namespace
+	}
+
+	svc, err := opt.kubeClient.CoreV1().Services(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %s/%s does not have any selector", namespace, ref.Name)
+	}
+
+	podList, err := opt.kubeClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []core.Pod
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}
+
+// peerHost returns the first label of the host of the peer address, i.e. the pod name of vault-0.vault-internal:8201
+func peerHost(address string) string {
+	host := address
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
+
+func waitForAutopilotHealthy(vc *api.Client, waitTimeout int32) error {
+	klog.Infoln("Waiting for autopilot to report the cluster healthy....")
+
+	return wait.PollUntilContextTimeout(context.Background(), 5*time.Second, time.Duration(waitTimeout)*time.Second, true, func(ctx context.Context) (done bool, err error) {
+		state, err := vc.Sys().RaftAutopilotState()
+		if err != nil {
+			klog.Infof("Unable to read autopilot state. Reason: %v.\nRetrying after 5 seconds....", err)
+			return false, nil
+		}
+		if state == nil || !state.Healthy {
+			klog.Infoln("Autopilot reports the cluster unhealthy. Retrying after 5 seconds....")
+			return false, nil
+		}
+
+		klog.Infoln("Autopilot reports the cluster healthy")
+		return true, nil
+	})
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s. Reason: %w", filepath.Base(path), err)
+	}
+	return nil
+}
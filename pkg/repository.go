@@ -0,0 +1,151 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	license "go.bytebuilders.dev/license-verifier/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	appcatalog_cs "kmodules.xyz/custom-resources/client/clientset/versioned"
+	v1 "kmodules.xyz/offshoot-api/api/v1"
+)
+
+// addRepositoryFlags adds the flags required to access the backend repository
+func (opt *vaultOptions) addRepositoryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opt.setupOptions.Provider, "provider", opt.setupOptions.Provider, "Backend provider (i.e. gcs, s3, azure etc)")
+	cmd.Flags().StringVar(&opt.setupOptions.Bucket, "bucket", opt.setupOptions.Bucket, "Name of the cloud bucket/container (keep empty for local backend)")
+	cmd.Flags().StringVar(&opt.setupOptions.Endpoint, "endpoint", opt.setupOptions.Endpoint, "Endpoint for s3/s3 compatible backend or REST backend URL")
+	cmd.Flags().StringVar(&opt.setupOptions.Region, "region", opt.setupOptions.Region, "Region for s3/s3 compatible backend")
+	cmd.Flags().StringVar(&opt.setupOptions.Path, "path", opt.setupOptions.Path, "Directory inside the bucket where backup will be stored")
+	cmd.Flags().StringVar(&opt.storageSecret.Name, "storage-secret-name", opt.storageSecret.Name, "Name of the storage secret")
+	cmd.Flags().StringVar(&opt.storageSecret.Namespace, "storage-secret-namespace", opt.storageSecret.Namespace, "Namespace of the storage secret")
+
+	cmd.Flags().StringVar(&opt.setupOptions.ScratchDir, "scratch-dir", opt.setupOptions.ScratchDir, "Temporary directory")
+	cmd.Flags().BoolVar(&opt.setupOptions.EnableCache, "enable-cache", opt.setupOptions.EnableCache, "Specify whether to enable caching for restic")
+	cmd.Flags().Int64Var(&opt.setupOptions.MaxConnections, "max-connections", opt.setupOptions.MaxConnections, "Specify maximum concurrent connections for GCS, Azure and B2 backend")
+}
+
+// prepareClients builds the Kubernetes clients from the given kubeconfig
+func (opt *vaultOptions) prepareClients(masterURL, kubeconfigPath string) error {
+	config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	opt.config = config
+
+	opt.kubeClient, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	opt.catalogClient, err = appcatalog_cs.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// newRepositoryWrapper returns a restic wrapper for the backend repository.
+// It does not contact the VaultServer, so it can be used while the VaultServer is unavailable.
+func (opt *vaultOptions) newRepositoryWrapper() (*restic.ResticWrapper, error) {
+	var err error
+	err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
+	if err != nil {
+		return nil, err
+	}
+
+	opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// apply nice, ionice settings from env
+	opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return restic.NewResticWrapper(opt.setupOptions)
+}
+
+// fetchBackupSet downloads the given snapshot into a temporary directory inside the scratch directory.
+// It returns the directory holding the backup set. The caller is responsible for removing the temporary directory.
+func (opt *vaultOptions) fetchBackupSet(w *restic.ResticWrapper, snapshotID string) (string, string, *restic.Snapshot, error) {
+	snapshots, err := w.ListSnapshots([]string{snapshotID})
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to get snapshot %s. Reason: %w", snapshotID, err)
+	}
+	if len(snapshots) == 0 {
+		return "", "", nil, fmt.Errorf("snapshot %s not found", snapshotID)
+	}
+	snapshot := snapshots[0]
+	if len(snapshot.Paths) != 1 {
+		return "", "", nil, fmt.Errorf("snapshot %s has %d paths, expected exactly one", snapshotID, len(snapshot.Paths))
+	}
+
+	tmpDir, err := os.MkdirTemp(opt.setupOptions.ScratchDir, "vault-snapshot-")
+	if err != nil {
+		return "", "", nil, err
+	}
+	if _, err := w.DownloadSnapshot(snapshot.ID, tmpDir); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return "", "", nil, err
+	}
+
+	return tmpDir, filepath.Join(tmpDir, snapshot.Paths[0]), &snapshot, nil
+}
+
+// listKeyFiles returns the names of the unseal keys & root token files of the backup set stored in dir
+func listKeyFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isKeyFile(entry.Name()) {
+			continue
+		}
+		keys = append(keys, entry.Name())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// isKeyFile checks whether the file of the backup set holds an unseal key or root token
+func isKeyFile(name string) bool {
+	switch name {
+	case VaultSnapshotFile, VaultManifestFile, VaultRaftConfigurationFile, VaultAutopilotConfigurationFile:
+		return false
+	}
+	return true
+}
@@ -19,11 +19,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/vault/pkg/store"
 
+	"github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 	license "go.bytebuilders.dev/license-verifier/kubernetes"
 	"gomodules.xyz/flags"
@@ -59,7 +63,21 @@ func NewCmdRestore() *cobra.Command {
 		Short:             "Restores Vault Backup",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			flags.EnsureRequiredFlags(cmd, "appbinding", "provider", "storage-secret-name", "storage-secret-namespace")
+			if opt.bundleFile != "" {
+				flags.EnsureRequiredFlags(cmd, "bundle-passphrase-file")
+				if len(opt.restoreOptions.Snapshots) != 0 || opt.restoreBefore != "" || opt.raftIndexBefore != 0 {
+					return fmt.Errorf("--from-bundle can not be used with --snapshot, --restore-before or --raft-index-before")
+				}
+			}
+			if opt.standalone {
+				return opt.runStandaloneRestore()
+			}
+
+			if opt.bundleFile != "" {
+				flags.EnsureRequiredFlags(cmd, "appbinding")
+			} else {
+				flags.EnsureRequiredFlags(cmd, "appbinding", "provider", "storage-secret-name", "storage-secret-namespace")
+			}
 
 			// prepare client
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
@@ -85,21 +103,10 @@ func NewCmdRestore() *cobra.Command {
 				Namespace:  opt.appBindingNamespace,
 			}
 
-			var restoreOutput *restic.RestoreOutput
+			var restoreOutput *RestoreOutput
 			restoreOutput, err = opt.restoreVault(targetRef)
 			if err != nil {
-				restoreOutput = &restic.RestoreOutput{
-					RestoreTargetStatus: api_v1beta1.RestoreMemberStatus{
-						Ref: targetRef,
-						Stats: []api_v1beta1.HostRestoreStats{
-							{
-								Hostname: opt.restoreOptions.Host,
-								Phase:    api_v1beta1.HostRestoreFailed,
-								Error:    err.Error(),
-							},
-						},
-					},
-				}
+				restoreOutput = opt.failedRestoreOutput(targetRef, err)
 			}
 			// If output directory specified, then write the output in "output.json" file in the specified directory
 			if opt.outputDir != "" {
@@ -133,6 +140,10 @@ func NewCmdRestore() *cobra.Command {
 	cmd.Flags().StringVar(&opt.restoreOptions.Host, "hostname", opt.restoreOptions.Host, "Name of the host machine")
 	cmd.Flags().StringVar(&opt.restoreOptions.SourceHost, "source-hostname", opt.restoreOptions.SourceHost, "Name of the host from where data will be restored")
 	cmd.Flags().StringSliceVar(&opt.restoreOptions.Snapshots, "snapshot", opt.restoreOptions.Snapshots, "Snapshot to restore")
+	cmd.Flags().StringVar(&opt.restoreBefore, "restore-before", opt.restoreBefore, "Restore the newest snapshot taken before this RFC3339 timestamp")
+	cmd.Flags().Uint64Var(&opt.raftIndexBefore, "raft-index-before", opt.raftIndexBefore, "Restore the newest snapshot whose Raft index is lower than this index")
+	cmd.Flags().StringVar(&opt.bundleFile, "from-bundle", opt.bundleFile, "Restore from the bundle created by export-bundle instead of the repository (- for stdin)")
+	cmd.Flags().StringVar(&opt.bundlePassphraseFile, "bundle-passphrase-file", opt.bundlePassphraseFile, "Path of the file holding the passphrase to decrypt the bundle")
 
 	cmd.Flags().StringVar(&opt.interimDataDir, "interim-data-dir", opt.interimDataDir, "Directory where the restored data will be stored temporarily before injecting into the desired NATS Server")
 	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
@@ -140,14 +151,18 @@ func NewCmdRestore() *cobra.Command {
 	// vault related flags
 	// -force implies that snapshot will be restore forcefully, required when restoring on a different vault server
 	cmd.Flags().BoolVar(&opt.force, "force", opt.force, "Specify whether to force restore or not")
+	cmd.Flags().BoolVar(&opt.keysOnly, "keys-only", opt.keysOnly, "Specify whether to restore only the unseal keys & root token into the store, without restoring the Raft snapshot")
+	cmd.Flags().BoolVar(&opt.removeStalePeers, "remove-stale-peers", opt.removeStalePeers, "Specify whether to remove the Raft peers that don't exist behind the service of the app binding after restore")
 
 	cmd.Flags().StringVar(&opt.keyPrefix, "key-prefix", opt.keyPrefix, "prefix that will be append to root-token & unseal-keys")
 	cmd.Flags().StringVar(&opt.oldKeyPrefix, "old-key-prefix", opt.oldKeyPrefix, "old prefix that was appended to root-token & unseal-keys")
+	opt.addSecondaryStoreFlags(cmd)
+	opt.addStandaloneFlags(cmd)
 
 	return cmd
 }
 
-func (opt *vaultOptions) restoreVault(targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, error) {
+func (opt *vaultOptions) restoreVault(targetRef api_v1beta1.TargetRef) (*RestoreOutput, error) {
 	var err error
 
 	err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
@@ -155,19 +170,22 @@ func (opt *vaultOptions) restoreVault(targetRef api_v1beta1.TargetRef) (*restic.
 		return nil, err
 	}
 
-	opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
+	// a bundle is restored without any repository
+	if opt.bundleFile == "" {
+		opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
 
-	// apply nice, ionice settings from env
-	opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
-	if err != nil {
-		return nil, err
-	}
-	opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
-	if err != nil {
-		return nil, err
+		// apply nice, ionice settings from env
+		opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	appBinding, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).Get(context.TODO(), opt.appBindingName, metav1.GetOptions{})
@@ -190,6 +208,10 @@ func (opt *vaultOptions) restoreVault(targetRef api_v1beta1.TargetRef) (*restic.
 		return nil, err
 	}
 
+	if opt.keysOnly {
+		return opt.restoreVaultKeys(opt.newAppBindingTarget(appBinding, parameters), targetRef)
+	}
+
 	session := opt.newSessionWrapper(VaultCMD)
 
 	vaultClient, err := newVaultClient(appBinding)
@@ -201,51 +223,310 @@ func (opt *vaultOptions) restoreVault(targetRef api_v1beta1.TargetRef) (*restic.
 		return nil, err
 	}
 
-	if err := session.waitForVaultReady(vaultClient, opt.waitTimeout); err != nil {
+	// an uninitialized VaultServer never becomes ready, so it is bootstrapped from the snapshot instead
+	initialized, err := waitForVaultInitStatus(vaultClient, opt.waitTimeout)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := session.setVaultToken(opt.kubeClient, appBinding, parameters.BackupTokenSecretRef); err != nil {
+	if initialized {
+		if err := session.waitForVaultReady(vaultClient, opt.waitTimeout); err != nil {
+			return nil, err
+		}
+
+		if err := session.setVaultToken(opt.kubeClient, appBinding, parameters.BackupTokenSecretRef); err != nil {
+			return nil, err
+		}
+
+		if err := session.setVaultConnectionParameters(vaultClient, appBinding); err != nil {
+			return nil, err
+		}
+	}
+
+	klog.Infof("Trying to restore snapshot for VaultServer %s/%s\n", appBinding.Namespace, appBinding.Name)
+
+	return opt.restoreVaultData(session, vaultClient, initialized, opt.newAppBindingTarget(appBinding, parameters), targetRef)
+}
+
+// restoreVaultData restores the backup set from the repository or from the bundle, then restores the snapshot into the connected VaultServer.
+// An uninitialized VaultServer is bootstrapped from the snapshot, which requires the AppBinding to find its pods.
+func (opt *vaultOptions) restoreVaultData(session *sessionWrapper, vaultClient *api.Client, initialized bool, target *vaultTarget, targetRef api_v1beta1.TargetRef) (*RestoreOutput, error) {
+	if !initialized && target.appBinding == nil {
+		return nil, fmt.Errorf("VaultServer is not initialized, bootstrapping is only supported with an app binding")
+	}
+	if opt.removeStalePeers && target.appBinding == nil {
+		return nil, fmt.Errorf("removing stale Raft peers is only supported with an app binding")
+	}
+
+	restoreOutput, resolved, err := opt.restoreBackupSet(targetRef)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := session.setVaultConnectionParameters(vaultClient, appBinding); err != nil {
+	if _, err := opt.prepareVaultSnapshot(); err != nil {
 		return nil, err
 	}
 
-	klog.Infof("Trying to restore snapshot for VaultServer %s/%s\n", appBinding.Namespace, appBinding.Name)
+	// the snapshot of a different cluster is restored forcefully, and its keys must be written into the store of the target.
+	// A bootstrapped cluster is always restored from a different cluster than its temporary barrier.
+	forced := opt.force || !initialized
+
+	// the keys are checked against the store before the snapshot is restored, so that a refused overwrite
+	// leaves both the cluster and the store untouched
+	var migration *keyMigration
+	if forced {
+		if migration, err = opt.prepareKeyMigration(target); err != nil {
+			return nil, err
+		}
+		defer closeStore(migration.st)
+	}
+
+	if initialized {
+		if err := opt.restoreVaultSnapshot(session, opt.force); err != nil {
+			return nil, err
+		}
+	} else if err := opt.bootstrapVaultCluster(session, vaultClient, target.appBinding); err != nil {
+		return nil, err
+	}
+
+	var divergence []string
+	if forced {
+		if divergence, err = opt.applyKeyMigration(target, migration); err != nil {
+			return nil, err
+		}
+	}
+
+	// the snapshot has already been restored at this point, so the Raft configuration is restored on best effort basis
+	var missingPeers, removedPeers []string
+	postClient, err := opt.postRestoreClient(vaultClient, session, forced)
+	if err != nil {
+		return nil, err
+	}
+	readyErr := session.waitForVaultReady(postClient, opt.waitTimeout)
+	if readyErr != nil {
+		klog.Warningf("failed to restore Raft configuration. Reason: %v", readyErr)
+	} else if missingPeers, err = opt.restoreRaftConfiguration(postClient); err != nil {
+		klog.Warningf("failed to restore Raft configuration. Reason: %v", err)
+	}
+
+	// the restore is successful only if the cluster is healthy after removing the stale peers
+	if opt.removeStalePeers {
+		if readyErr != nil {
+			return nil, readyErr
+		}
+		removedPeers, err = opt.reconcileRaftPeers(postClient, target.appBinding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vaultStats := VaultStats{
+		Hostname:   opt.restoreOptions.Host,
+		AppBinding: target.name(),
+		Leader:     session.sh.Env[EnvVaultAddress],
+		UnsealMode: target.unsealMode,
+	}
+	if keys, err := listKeyFiles(opt.interimDataDir); err == nil {
+		vaultStats.KeyCount = len(keys)
+	}
+	vaultStats.MissingPeers = missingPeers
+	vaultStats.RemovedPeers = removedPeers
+	vaultStats.StoreDivergence = divergence
+	if resolved != nil {
+		vaultStats.SnapshotID = resolved.ID
+		vaultStats.Reason = resolved.Vault.Reason
+	}
+	// the snapshot has already been restored at this point, so don't fail the restore for missing stats
+	if err := vaultStats.setSnapshotInfo(filepath.Join(opt.interimDataDir, VaultSnapshotFile)); err != nil {
+		klog.Warningf("failed to read snapshot info. Reason: %v", err)
+	}
+	if err := vaultStats.setClusterInfo(vaultClient); err != nil {
+		klog.Warningf("failed to read cluster info. Reason: %v", err)
+	}
+
+	return &RestoreOutput{
+		RestoreOutput: *restoreOutput,
+		VaultStats:    []VaultStats{vaultStats},
+	}, nil
+}
+
+func (opt *vaultOptions) failedRestoreOutput(targetRef api_v1beta1.TargetRef, err error) *RestoreOutput {
+	return &RestoreOutput{
+		RestoreOutput: restic.RestoreOutput{
+			RestoreTargetStatus: api_v1beta1.RestoreMemberStatus{
+				Ref: targetRef,
+				Stats: []api_v1beta1.HostRestoreStats{
+					{
+						Hostname: opt.restoreOptions.Host,
+						Phase:    api_v1beta1.HostRestoreFailed,
+						Error:    err.Error(),
+					},
+				},
+			},
+		},
+	}
+}
+
+// restoreBackupSet restores the backup set into the interim directory, either from the repository or from the bundle.
+// It returns the snapshot resolved by the point-in-time restore options, if there is any.
+func (opt *vaultOptions) restoreBackupSet(targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, *backupInfo, error) {
+	if opt.bundleFile != "" {
+		restoreOutput, err := opt.extractBundle(targetRef)
+		return restoreOutput, nil, err
+	}
 
 	opt.restoreOptions.RestorePaths = []string{opt.interimDataDir}
 
 	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var resolved *backupInfo
+	if opt.restoreBefore != "" || opt.raftIndexBefore != 0 {
+		resolved, err = opt.resolveSnapshot(resticWrapper)
+		if err != nil {
+			return nil, nil, err
+		}
+		opt.restoreOptions.Snapshots = []string{resolved.ID}
+	}
+
+	if opt.keysOnly {
+		// the snapshot is not needed, so don't download it from the repository
+		excludes, err := opt.snapshotExcludes(resticWrapper)
+		if err != nil {
+			return nil, nil, err
+		}
+		opt.restoreOptions.Exclude = append(opt.restoreOptions.Exclude, excludes...)
 	}
 
 	restoreOutput, err := resticWrapper.RunRestore(opt.restoreOptions, targetRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	return restoreOutput, resolved, nil
+}
+
+// snapshotExcludes returns the restic exclude patterns of the Raft snapshot of the backup set. The patterns match the path
+// recorded in the restic snapshot, which is the interim directory of the backup and may differ from the one of the restore.
+func (opt *vaultOptions) snapshotExcludes(w *restic.ResticWrapper) ([]string, error) {
+	// without any snapshot ID, the latest snapshot of the interim directory is restored
+	paths := []string{opt.interimDataDir}
+	if len(opt.restoreOptions.Snapshots) != 0 {
+		snapshots, err := w.ListSnapshots(opt.restoreOptions.Snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots. Reason: %w", err)
+		}
+		paths = nil
+		for _, snapshot := range snapshots {
+			paths = append(paths, snapshot.Paths...)
+		}
+	}
+
+	var excludes []string
+	for _, path := range paths {
+		excludes = append(excludes, filepath.Join(path, VaultSnapshotFile), filepath.Join(path, VaultSnapshotDir))
+	}
+	return excludes, nil
+}
+
+// restoreVaultKeys restores only the unseal keys & root token of the backup set into the store of the target.
+// The Raft data of the VaultServer is left untouched, so the VaultServer does not need to be reachable.
+func (opt *vaultOptions) restoreVaultKeys(target *vaultTarget, targetRef api_v1beta1.TargetRef) (*RestoreOutput, error) {
+	klog.Infoln("Trying to restore unseal keys & root token only")
+
+	restoreOutput, resolved, err := opt.restoreBackupSet(targetRef)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := opt.restoreVaultSnapshot(session); err != nil {
+	migration, err := opt.prepareKeyMigration(target)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore(migration.st)
+	divergence, err := opt.applyKeyMigration(target, migration)
+	if err != nil {
 		return nil, err
 	}
 
-	if opt.force {
-		if err := opt.migrateVaultTokenKeys(appBinding, parameters); err != nil {
-			return nil, err
+	vaultStats := VaultStats{
+		Hostname:        opt.restoreOptions.Host,
+		AppBinding:      target.name(),
+		UnsealMode:      target.unsealMode,
+		StoreDivergence: divergence,
+	}
+	if keys, err := listKeyFiles(opt.interimDataDir); err == nil {
+		vaultStats.KeyCount = len(keys)
+	}
+	if resolved != nil {
+		vaultStats.SnapshotID = resolved.ID
+		vaultStats.Reason = resolved.Vault.Reason
+	}
+
+	return &RestoreOutput{
+		RestoreOutput: *restoreOutput,
+		VaultStats:    []VaultStats{vaultStats},
+	}, nil
+}
+
+// resolveSnapshot finds the newest snapshot that matches the point-in-time restore options
+func (opt *vaultOptions) resolveSnapshot(w *restic.ResticWrapper) (*backupInfo, error) {
+	if len(opt.restoreOptions.Snapshots) != 0 {
+		return nil, fmt.Errorf("--snapshot can't be used together with --restore-before or --raft-index-before")
+	}
+
+	filter := snapshotFilter{
+		Hostname: opt.restoreOptions.SourceHost,
+	}
+	if filter.Hostname == "" {
+		filter.Hostname = opt.restoreOptions.Host
+	}
+
+	var criteria []string
+	if opt.restoreBefore != "" {
+		t, err := time.Parse(time.RFC3339, opt.restoreBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q for --restore-before. Reason: %w", opt.restoreBefore, err)
 		}
+		filter.Before = &t
+		criteria = append(criteria, fmt.Sprintf("taken before %s", t.Format(time.RFC3339)))
+	}
+	if opt.raftIndexBefore != 0 {
+		criteria = append(criteria, fmt.Sprintf("Raft index lower than %d", opt.raftIndexBefore))
 	}
 
-	return restoreOutput, nil
+	backups, err := listBackups(w, filter, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// backups are sorted newest first
+	for i := range backups {
+		b := backups[i]
+		if opt.raftIndexBefore != 0 && b.Vault.RaftIndex == 0 {
+			// the Raft index of a snapshot without tags is only recorded in its manifest
+			b.readManifest(w)
+		}
+		if opt.raftIndexBefore != 0 && (b.Vault.RaftIndex == 0 || b.Vault.RaftIndex >= opt.raftIndexBefore) {
+			continue
+		}
+
+		b.Vault.Reason = fmt.Sprintf("newest snapshot of host %s with %s (taken at %s, Raft index %d)",
+			filter.Hostname, strings.Join(criteria, " and "), b.Time.Format(time.RFC3339), b.Vault.RaftIndex)
+		klog.Infof("Resolved snapshot %s: %s", b.ID, b.Vault.Reason)
+		return &b, nil
+	}
+
+	return nil, fmt.Errorf("no snapshot of host %s found with %s", filter.Hostname, strings.Join(criteria, " and "))
 }
 
-func (opt *vaultOptions) restoreVaultSnapshot(session *sessionWrapper) error {
+func (opt *vaultOptions) restoreVaultSnapshot(session *sessionWrapper, force bool) error {
 	klog.Infoln("Trying to restore snapshot")
 	session.cmd.Args = append(session.cmd.Args, "operator", "raft", "snapshot", "restore")
 
 	// -force is required for different vault cluster snapshot restoration
-	if opt.force {
+	if force {
 		session.cmd.Args = append(session.cmd.Args, "-force")
 	}
 
@@ -263,44 +544,128 @@ func (opt *vaultOptions) restoreVaultSnapshot(session *sessionWrapper) error {
 	return nil
 }
 
-func (opt *vaultOptions) migrateVaultTokenKeys(appBinding *appcatalog.AppBinding, params vaultconfig.VaultServerConfiguration) error {
-	if params.Unsealer == nil {
-		return fmt.Errorf("unsealer spec is nil")
+// keyMigration holds the unseal keys & root token of the backup set to be written into the store of the target
+type keyMigration struct {
+	st     store.StoreInterface
+	keys   []string
+	values map[string]string
+	// overwrites are the keys present in the store with a different value
+	overwrites []string
+	shares     int
+}
+
+// prepareKeyMigration reads the unseal keys & root token from the interim directory and checks them against the store of the target.
+// It fails if existing keys would be overwritten while overwriting is not allowed.
+// The caller must close the store of the returned migration.
+func (opt *vaultOptions) prepareKeyMigration(target *vaultTarget) (*keyMigration, error) {
+	st, err := target.newStore()
+	if err != nil {
+		return nil, err
 	}
 
-	klog.Infoln("Trying to read, set unseal keys & root token")
-	// for restore:
-	// i. read the unseal keys & root token from the interim directory
-	// ii. Set the unseal keys & root token to store based on the unseal mode
-	st, err := store.NewStore(opt.kubeClient, appBinding, params.Unsealer)
+	m, err := opt.newKeyMigration(st, target)
 	if err != nil {
-		return err
+		closeStore(st)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (opt *vaultOptions) newKeyMigration(st store.StoreInterface, target *vaultTarget) (*keyMigration, error) {
+	// the backup may hold a different number of unseal keys than the secret shares of the target
+	unsealKeys, err := opt.backedUpUnsealKeys()
+	if err != nil {
+		return nil, err
 	}
 
 	var oldKeys []string
 	oldKeys = append(oldKeys, opt.tokenName(opt.oldKeyPrefix))
-	for i := 0; i < int(params.Unsealer.SecretShares); i++ {
+	for i := range unsealKeys {
 		oldKeys = append(oldKeys, opt.unsealKeyName(opt.oldKeyPrefix, i))
 	}
 
 	var newKeys []string
 	newKeys = append(newKeys, opt.tokenName(opt.keyPrefix))
-	for i := 0; i < int(params.Unsealer.SecretShares); i++ {
+	for i := range unsealKeys {
 		newKeys = append(newKeys, opt.unsealKeyName(opt.keyPrefix, i))
 	}
 
+	values := map[string]string{}
 	for idx, oldKey := range oldKeys {
 		value, err := opt.read(oldKey)
 		if err != nil {
-			return fmt.Errorf("failed to read key %s. Reason: %w", oldKey, err)
+			return nil, fmt.Errorf("failed to read key %s. Reason: %w", oldKey, err)
 		}
+		values[newKeys[idx]] = value
+	}
+
+	overwrites, err := existingKeys(st, values)
+	if err != nil {
+		return nil, err
+	}
+	if len(overwrites) > 0 && !target.overwriteExisting {
+		return nil, fmt.Errorf("keys %q already exist in the unsealer store with different values, set overwriteExisting in the unsealer spec to overwrite them", overwrites)
+	}
 
-		if err := st.Set(newKeys[idx], value); err != nil {
-			return fmt.Errorf("failed to set key %s. Reason: %w", newKeys[idx], err)
+	return &keyMigration{
+		st:         st,
+		keys:       newKeys,
+		values:     values,
+		overwrites: overwrites,
+		shares:     len(unsealKeys),
+	}, nil
+}
+
+// applyKeyMigration archives the keys to be overwritten and sets the unseal keys & root token into the store of the target.
+// It returns the differences found between the copies of a mirrored store.
+func (opt *vaultOptions) applyKeyMigration(target *vaultTarget, m *keyMigration) ([]string, error) {
+	klog.Infoln("Trying to read, set unseal keys & root token")
+
+	now := time.Now()
+	for _, key := range m.overwrites {
+		rollback, err := store.Archive(m.st, key, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to archive key %s before overwriting it. Reason: %w", key, err)
 		}
+		klog.Warningf("Overwriting key %s: %s", key, rollback)
 	}
 
-	return nil
+	for _, key := range m.keys {
+		if err := m.st.Set(key, m.values[key]); err != nil {
+			return nil, fmt.Errorf("failed to set key %s. Reason: %w", key, err)
+		}
+	}
+
+	if !target.overwriteExisting {
+		klog.Infoln("Keeping the stale unseal keys in the store as overwriting existing keys is not allowed")
+	} else if err := opt.deleteStaleUnsealKeys(m.st, opt.keyPrefix, m.shares, now); err != nil {
+		return nil, err
+	}
+	return storeDivergence(m.st), nil
+}
+
+// existingKeys returns the keys that are present in the store with a different value
+func existingKeys(st store.StoreInterface, values map[string]string) ([]string, error) {
+	var keys []string
+	for key, value := range values {
+		exists, err := st.Exists(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check key %s. Reason: %w", key, err)
+		}
+		if !exists {
+			continue
+		}
+
+		current, err := st.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key %s. Reason: %w", key, err)
+		}
+		if current != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 func (opt *vaultOptions) read(key string) (string, error) {
@@ -0,0 +1,296 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+	shell "gomodules.xyz/go-sh"
+	authv1 "k8s.io/api/authentication/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+const (
+	EventReasonUnsealKeysRevealed = "UnsealKeysRevealed"
+	// AnnotationUnsealKeysRevealed records the last reveal of the backed up unseal keys on the AppBinding
+	AnnotationUnsealKeysRevealed = "vault.stash.appscode.com/unseal-keys-revealed"
+
+	GPGCMD = "gpg"
+)
+
+// revealRecord is the audit record of revealing the backed up unseal keys
+type revealRecord struct {
+	User string `json:"user"`
+	// KubeconfigUser is the identity of the kubeconfig, recorded when the user could not be verified by the API server
+	KubeconfigUser string    `json:"kubeconfigUser,omitempty"`
+	Snapshot       string    `json:"snapshot"`
+	Keys           int       `json:"keys"`
+	Reason         string    `json:"reason,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+func NewCmdRevealKeys() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		snapshotID     string
+		outputFile     = StdStream
+		reason         string
+		confirm        bool
+		gpgDecrypt     bool
+		user           string
+
+		opt = vaultOptions{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "reveal-keys",
+		Short:             "Reveals the backed up unseal keys to unseal Vault by hand (break-glass)",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "snapshot", "appbinding", "provider", "storage-secret-name", "storage-secret-namespace")
+
+			if !confirm {
+				return fmt.Errorf("revealing unseal keys is a break-glass operation, it must be confirmed with --i-understand-this-reveals-unseal-keys")
+			}
+
+			if err := opt.prepareClients(masterURL, kubeconfigPath); err != nil {
+				return err
+			}
+
+			appBinding, err := opt.catalogClient.AppcatalogV1alpha1().AppBindings(opt.appBindingNamespace).Get(context.TODO(), opt.appBindingName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			resticWrapper, err := opt.newRepositoryWrapper()
+			if err != nil {
+				return err
+			}
+
+			record, err := opt.newRevealRecord(user, kubeconfigPath)
+			if err != nil {
+				return err
+			}
+
+			keys, err := opt.readSnapshotUnsealKeys(resticWrapper, snapshotID, gpgDecrypt)
+			if err != nil {
+				return err
+			}
+
+			// the keys are revealed only after the reveal has been recorded
+			record.Snapshot = snapshotID
+			record.Keys = len(keys)
+			record.Reason = reason
+			record.Time = time.Now().UTC()
+			if err := opt.recordReveal(appBinding, record); err != nil {
+				return fmt.Errorf("failed to record the reveal of unseal keys. Reason: %w", err)
+			}
+
+			return writeUnsealKeys(outputFile, keys)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	opt.addRepositoryFlags(cmd)
+
+	cmd.Flags().StringVar(&opt.appBindingName, "appbinding", opt.appBindingName, "Name of the app binding of the VaultServer")
+	cmd.Flags().StringVar(&opt.appBindingNamespace, "appbinding-namespace", opt.appBindingNamespace, "Namespace of the app binding")
+	cmd.Flags().StringVar(&snapshotID, "snapshot", snapshotID, "Snapshot to reveal the unseal keys from")
+	cmd.Flags().StringVar(&opt.oldKeyPrefix, "key-prefix", opt.oldKeyPrefix, "prefix that was appended to the unseal-keys in the snapshot")
+	cmd.Flags().StringVar(&outputFile, "output-file", outputFile, "Path of the file to write the unseal keys (- for stdout)")
+	cmd.Flags().StringVar(&reason, "reason", reason, "Reason of revealing the unseal keys, recorded in the audit log")
+	cmd.Flags().StringVar(&user, "user", user, "User to record in the audit log, when the current user can not be found from the API server")
+	cmd.Flags().BoolVar(&gpgDecrypt, "gpg-decrypt", gpgDecrypt, "Specify whether the unseal keys are PGP encrypted and must be decrypted with the custodian key of the local gpg keyring")
+	cmd.Flags().BoolVar(&confirm, "i-understand-this-reveals-unseal-keys", confirm, "Confirm revealing the unseal keys")
+
+	return cmd
+}
+
+// readSnapshotUnsealKeys downloads the snapshot and reads its unseal keys. The downloaded data is removed before returning.
+func (opt *vaultOptions) readSnapshotUnsealKeys(w *restic.ResticWrapper, snapshotID string, gpgDecrypt bool) ([]string, error) {
+	tmpDir, dir, _, err := opt.fetchBackupSet(w, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opt.interimDataDir = dir
+	keys, err := opt.backedUpUnsealKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if gpgDecrypt {
+		for i := range keys {
+			if keys[i], err = decryptWithGPG(keys[i]); err != nil {
+				return nil, fmt.Errorf("failed to decrypt unseal key %d. Reason: %w", i, err)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// decryptWithGPG decrypts the base64 encoded PGP encrypted unseal key, the way Vault returns it when initialized with pgp_keys
+func decryptWithGPG(key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+
+	sh := shell.NewSession()
+	sh.ShowCMD = false
+	out, err := sh.SetInput(string(data)).Command(GPGCMD, "--batch", "--quiet", "--decrypt").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// newRevealRecord returns the audit record with the Kubernetes user running the command.
+// If the API server can not tell the user, the reveal is refused unless the user is given explicitly,
+// and the identity of the kubeconfig is recorded along with it.
+func (opt *vaultOptions) newRevealRecord(user, kubeconfigPath string) (revealRecord, error) {
+	review, err := opt.kubeClient.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(), &authv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err == nil {
+		if user != "" && user != review.Status.UserInfo.Username {
+			klog.Warningf("Ignoring user %s, the current user is %s", user, review.Status.UserInfo.Username)
+		}
+		return revealRecord{User: review.Status.UserInfo.Username}, nil
+	}
+	if user == "" {
+		return revealRecord{}, fmt.Errorf("failed to find the current user, specify it with --user to reveal the unseal keys. Reason: %w", err)
+	}
+	klog.Warningf("failed to find the current user, recording the user %s. Reason: %v", user, err)
+	return revealRecord{
+		User:           user,
+		KubeconfigUser: kubeconfigUser(kubeconfigPath, opt.config),
+	}, nil
+}
+
+// kubeconfigUser returns the user of the current context of the kubeconfig
+func kubeconfigUser(kubeconfigPath string, config *restclient.Config) string {
+	if config != nil && config.Username != "" {
+		return config.Username
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	raw, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return "unknown"
+	}
+	if ctx, ok := raw.Contexts[raw.CurrentContext]; ok && ctx.AuthInfo != "" {
+		return ctx.AuthInfo
+	}
+	return "unknown"
+}
+
+// recordReveal records the reveal as a Kubernetes Event & an annotation on the AppBinding, and in the audit log
+func (opt *vaultOptions) recordReveal(appBinding *appcatalog.AppBinding, record revealRecord) error {
+	message := fmt.Sprintf("%d unseal key(s) of snapshot %s revealed by %s", record.Keys, record.Snapshot, record.User)
+	if record.KubeconfigUser != "" {
+		message = fmt.Sprintf("%s (unverified, kubeconfig user %s)", message, record.KubeconfigUser)
+	}
+	if record.Reason != "" {
+		message = fmt.Sprintf("%s. Reason: %s", message, record.Reason)
+	}
+	klog.Infof("AUDIT: %s", message)
+
+	_, err := opt.kubeClient.CoreV1().Events(appBinding.Namespace).Create(context.TODO(), &core.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: appBinding.Name + "-",
+			Namespace:    appBinding.Namespace,
+		},
+		InvolvedObject: core.ObjectReference{
+			APIVersion: appcatalog.SchemeGroupVersion.String(),
+			Kind:       appcatalog.ResourceKindApp,
+			Name:       appBinding.Name,
+			Namespace:  appBinding.Namespace,
+			UID:        appBinding.UID,
+		},
+		Reason:         EventReasonUnsealKeysRevealed,
+		Message:        message,
+		Type:           core.EventTypeWarning,
+		Source:         core.EventSource{Component: "stash-vault"},
+		FirstTimestamp: metav1.NewTime(record.Time),
+		LastTimestamp:  metav1.NewTime(record.Time),
+		Count:          1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationUnsealKeysRevealed: string(value),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = opt.catalogClient.AppcatalogV1alpha1().AppBindings(appBinding.Namespace).Patch(context.TODO(), appBinding.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func writeUnsealKeys(outputFile string, keys []string) error {
+	var w io.Writer = os.Stdout
+	if outputFile != StdStream {
+		// never overwrite an existing file, the revealed keys may end up somewhere unexpected otherwise
+		f, err := os.OpenFile(outputFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	for i, key := range keys {
+		if _, err := fmt.Fprintf(w, "Unseal Key %d: %s\n", i+1, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
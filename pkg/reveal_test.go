@@ -0,0 +1,82 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"testing"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNewRevealRecord(t *testing.T) {
+	cases := []struct {
+		name      string
+		reviewErr error
+		user      string
+		want      revealRecord
+		wantErr   bool
+	}{
+		{
+			name: "verified user",
+			want: revealRecord{User: "alice"},
+		},
+		{
+			name: "verified user wins over the given user",
+			user: "bob",
+			want: revealRecord{User: "alice"},
+		},
+		{
+			name:      "unverified user is refused",
+			reviewErr: errors.New("forbidden"),
+			wantErr:   true,
+		},
+		{
+			name:      "given user is recorded with the kubeconfig user",
+			reviewErr: errors.New("forbidden"),
+			user:      "bob",
+			want:      revealRecord{User: "bob", KubeconfigUser: "admin"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "selfsubjectreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if c.reviewErr != nil {
+					return true, nil, c.reviewErr
+				}
+				review := &authv1.SelfSubjectReview{}
+				review.Status.UserInfo.Username = "alice"
+				return true, review, nil
+			})
+			opt := &vaultOptions{kubeClient: client, config: &restclient.Config{Username: "admin"}}
+
+			got, err := opt.newRevealRecord(c.user, "")
+			if (err != nil) != c.wantErr {
+				t.Fatalf("newRevealRecord() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("newRevealRecord() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
@@ -43,6 +43,12 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(v.NewCmdVersion())
 	rootCmd.AddCommand(NewCmdBackup())
 	rootCmd.AddCommand(NewCmdRestore())
+	rootCmd.AddCommand(NewCmdInspect())
+	rootCmd.AddCommand(NewCmdListBackups())
+	rootCmd.AddCommand(NewCmdImportSnapshot())
+	rootCmd.AddCommand(NewCmdExportBundle())
+	rootCmd.AddCommand(NewCmdRevealKeys())
+	rootCmd.AddCommand(NewCmdMigrateStore())
 
 	return rootCmd
 }
@@ -0,0 +1,306 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	RaftSnapshotMetaFile = "meta.json"
+	RaftSnapshotSumsFile = "SHA256SUMS"
+)
+
+// raftSnapshotMeta is the content of meta.json inside a Vault Raft snapshot archive
+type raftSnapshotMeta struct {
+	Version            int               `json:"Version"`
+	ID                 string            `json:"ID"`
+	Index              uint64            `json:"Index"`
+	Term               uint64            `json:"Term"`
+	Configuration      raftConfiguration `json:"Configuration"`
+	ConfigurationIndex uint64            `json:"ConfigurationIndex"`
+	Size               int64             `json:"Size"`
+}
+
+type raftConfiguration struct {
+	Servers []raftServer `json:"Servers"`
+}
+
+type raftServer struct {
+	Suffrage int    `json:"Suffrage"`
+	ID       string `json:"ID"`
+	Address  string `json:"Address"`
+}
+
+// readRaftSnapshotMeta reads the Raft metadata from a snapshot saved by "vault operator raft snapshot save".
+// The snapshot is a gzip compressed tarball, and the metadata is stored in its meta.json entry.
+func readRaftSnapshotMeta(path string) (*raftSnapshotMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s. Reason: %w", path, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s. Reason: %w", path, err)
+		}
+		if hdr.Name != RaftSnapshotMetaFile {
+			continue
+		}
+
+		meta := &raftSnapshotMeta{}
+		if err := json.NewDecoder(tr).Decode(meta); err != nil {
+			return nil, fmt.Errorf("failed to decode %s of snapshot %s. Reason: %w", RaftSnapshotMetaFile, path, err)
+		}
+		return meta, nil
+	}
+
+	return nil, fmt.Errorf("%s not found in snapshot %s", RaftSnapshotMetaFile, path)
+}
+
+// snapshotEntry describes an entry of a Raft snapshot archive stored uncompressed in the backup set
+type snapshotEntry struct {
+	Name    string `json:"name"`
+	Mode    int64  `json:"mode"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA256  string `json:"sha256"`
+}
+
+// unpackSnapshot extracts the entries of the snapshot archive uncompressed into dir.
+// Unlike the gzip compressed archive, the extracted entries can be deduplicated by restic across backups.
+func unpackSnapshot(snapPath, dir string) ([]snapshotEntry, error) {
+	f, err := os.Open(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s. Reason: %w", snapPath, err)
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var entries []snapshotEntry
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s. Reason: %w", snapPath, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := validateEntryName(hdr.Name); err != nil {
+			return nil, err
+		}
+
+		sum, err := writeEntry(filepath.Join(dir, hdr.Name), tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s from snapshot. Reason: %w", hdr.Name, err)
+		}
+
+		entries = append(entries, snapshotEntry{
+			Name:    hdr.Name,
+			Mode:    hdr.Mode,
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime.Unix(),
+			SHA256:  sum,
+		})
+	}
+
+	return entries, nil
+}
+
+// packSnapshot reassembles a snapshot archive from the entries extracted by unpackSnapshot.
+// Every entry is verified against the checksum recorded at backup time and against the
+// SHA256SUMS entry of the snapshot itself before the archive is written.
+func packSnapshot(dir string, entries []snapshotEntry, snapPath string) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("no snapshot entry found to reassemble %s", snapPath)
+	}
+
+	for _, entry := range entries {
+		if err := validateEntryName(entry.Name); err != nil {
+			return err
+		}
+		sum, err := fileSHA256(filepath.Join(dir, entry.Name))
+		if err != nil {
+			return err
+		}
+		if sum != entry.SHA256 {
+			return fmt.Errorf("checksum mismatch for snapshot entry %s: expected %s, found %s", entry.Name, entry.SHA256, sum)
+		}
+	}
+
+	if err := verifySnapshotSums(dir); err != nil {
+		return err
+	}
+
+	f, err := os.Create(snapPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{
+			Name:     entry.Name,
+			Mode:     entry.Mode,
+			Size:     entry.Size,
+			ModTime:  time.Unix(entry.ModTime, 0),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyFile(tw, filepath.Join(dir, entry.Name)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// verifySnapshotSums verifies the extracted entries against the SHA256SUMS file of the snapshot
+func verifySnapshotSums(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, RaftSnapshotSumsFile))
+	if err != nil {
+		return fmt.Errorf("failed to read %s of the snapshot. Reason: %w", RaftSnapshotSumsFile, err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(fields[1], "*")
+		if err := validateEntryName(name); err != nil {
+			return err
+		}
+		sum, err := fileSHA256(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if sum != fields[0] {
+			return fmt.Errorf("checksum mismatch for snapshot entry %s: %s is corrupted", name, RaftSnapshotSumsFile)
+		}
+	}
+	return nil
+}
+
+// validateRaftSnapshot checks that the file is a readable Raft snapshot whose entries match its SHA256SUMS.
+// The entries are extracted into a temporary directory inside scratchDir, which is removed before returning.
+func validateRaftSnapshot(snapPath, scratchDir string) (*raftSnapshotMeta, error) {
+	tmpDir, err := os.MkdirTemp(scratchDir, "vault-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := unpackSnapshot(snapPath, tmpDir); err != nil {
+		return nil, err
+	}
+	if err := verifySnapshotSums(tmpDir); err != nil {
+		return nil, err
+	}
+	return readRaftSnapshotMeta(snapPath)
+}
+
+func validateEntryName(name string) error {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid snapshot entry name %q", name)
+	}
+	return nil
+}
+
+func writeEntry(path string, r io.Reader) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
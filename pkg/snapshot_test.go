@@ -0,0 +1,215 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestSnapshot writes a gzip compressed tarball laid out like a Vault Raft snapshot
+func writeTestSnapshot(t *testing.T, path string, files map[string]string, order []string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range order {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSnapshotFiles() (map[string]string, []string) {
+	files := map[string]string{
+		RaftSnapshotMetaFile: `{"Version":1,"ID":"2-42-1","Index":42,"Term":2,"Size":5}`,
+		"state.bin":          "state",
+	}
+	sums := ""
+	for _, name := range []string{RaftSnapshotMetaFile, "state.bin"} {
+		sum := sha256.Sum256([]byte(files[name]))
+		sums += fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	files[RaftSnapshotSumsFile] = sums
+	return files, []string{RaftSnapshotMetaFile, "state.bin", RaftSnapshotSumsFile}
+}
+
+func TestPackSnapshotRoundTrip(t *testing.T) {
+	files, order := testSnapshotFiles()
+	tmp := t.TempDir()
+	snapPath := filepath.Join(tmp, "vault.snap")
+	writeTestSnapshot(t, snapPath, files, order)
+
+	dir := filepath.Join(tmp, "unpacked")
+	entries, err := unpackSnapshot(snapPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(order) {
+		t.Fatalf("unpackSnapshot() returned %d entries, want %d", len(entries), len(order))
+	}
+	for i, entry := range entries {
+		if entry.Name != order[i] {
+			t.Errorf("entry %d is %s, want %s", i, entry.Name, order[i])
+		}
+	}
+
+	packed := filepath.Join(tmp, "packed.snap")
+	if err := packSnapshot(dir, entries, packed); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := validateRaftSnapshot(packed, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Index != 42 || meta.Term != 2 {
+		t.Errorf("reassembled snapshot has index %d term %d, want index 42 term 2", meta.Index, meta.Term)
+	}
+}
+
+func TestPackSnapshotErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry
+	}{
+		{
+			name: "corrupted entry",
+			modify: func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry {
+				if err := os.WriteFile(filepath.Join(dir, "state.bin"), []byte("corrupted"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				return entries
+			},
+		},
+		{
+			name: "missing entry",
+			modify: func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry {
+				if err := os.Remove(filepath.Join(dir, "state.bin")); err != nil {
+					t.Fatal(err)
+				}
+				return entries
+			},
+		},
+		{
+			// the manifest may have been tampered with along with the entry
+			name: "entry does not match the snapshot sums",
+			modify: func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry {
+				data := []byte("tampered")
+				if err := os.WriteFile(filepath.Join(dir, "state.bin"), data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(data)
+				for i := range entries {
+					if entries[i].Name == "state.bin" {
+						entries[i].SHA256 = hex.EncodeToString(sum[:])
+						entries[i].Size = int64(len(data))
+					}
+				}
+				return entries
+			},
+		},
+		{
+			name: "entry outside the directory",
+			modify: func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry {
+				return append(entries, snapshotEntry{Name: "../state.bin"})
+			},
+		},
+		{
+			name: "no entries",
+			modify: func(t *testing.T, dir string, entries []snapshotEntry) []snapshotEntry {
+				return nil
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files, order := testSnapshotFiles()
+			tmp := t.TempDir()
+			snapPath := filepath.Join(tmp, "vault.snap")
+			writeTestSnapshot(t, snapPath, files, order)
+
+			dir := filepath.Join(tmp, "unpacked")
+			entries, err := unpackSnapshot(snapPath, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries = c.modify(t, dir, entries)
+			if err := packSnapshot(dir, entries, filepath.Join(tmp, "packed.snap")); err == nil {
+				t.Error("packSnapshot() succeeded")
+			}
+		})
+	}
+}
+
+func TestUnpackSnapshotRejectsUnsafeEntries(t *testing.T) {
+	tmp := t.TempDir()
+	snapPath := filepath.Join(tmp, "vault.snap")
+	writeTestSnapshot(t, snapPath, map[string]string{"../escaped": "x"}, []string{"../escaped"})
+
+	if _, err := unpackSnapshot(snapPath, filepath.Join(tmp, "unpacked")); err == nil {
+		t.Error("unpackSnapshot() extracted an entry outside the directory")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("entry was written outside the directory: %v", err)
+	}
+}
+
+func TestValidateEntryName(t *testing.T) {
+	cases := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "state.bin"},
+		{name: RaftSnapshotMetaFile},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "../state.bin", wantErr: true},
+		{name: "dir/state.bin", wantErr: true},
+		{name: "/state.bin", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := validateEntryName(c.name); (err != nil) != c.wantErr {
+				t.Errorf("validateEntryName(%q) error = %v, wantErr %v", c.name, err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/vault/pkg/store"
+	"stash.appscode.dev/vault/pkg/store/local"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	v1 "kmodules.xyz/offshoot-api/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const UnsealModeLocalFile = "localFile"
+
+// standaloneConfig configures the backup & restore of a VaultServer without Kubernetes.
+// The flags take precedence over the values of the config file.
+type standaloneConfig struct {
+	// VaultAddress is the API address of the VaultServer
+	VaultAddress string `json:"vaultAddress,omitempty"`
+	// VaultCACert is the path of the CA certificate to verify the VaultServer
+	VaultCACert string `json:"vaultCACert,omitempty"`
+	// VaultTokenFile is the path of the file holding the Vault token, VAULT_TOKEN env is used if it is empty
+	VaultTokenFile string `json:"vaultTokenFile,omitempty"`
+	// CredentialsDir is the directory holding the repository credentials, one file per key (i.e. RESTIC_PASSWORD)
+	CredentialsDir string `json:"credentialsDir,omitempty"`
+	// KeyDir is the directory holding the unseal keys & root token, one file per key
+	KeyDir string `json:"keyDir,omitempty"`
+	// SecretShares is the number of unseal keys of the VaultServer
+	SecretShares int `json:"secretShares,omitempty"`
+	// OverwriteExisting allows restore to overwrite the unseal keys & root token present in the key directory
+	OverwriteExisting bool `json:"overwriteExisting,omitempty"`
+	// Repository is the backend repository
+	Repository standaloneRepository `json:"repository,omitempty"`
+}
+
+type standaloneRepository struct {
+	Provider string `json:"provider,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+func (opt *vaultOptions) addStandaloneFlags(cmd *cobra.Command) {
+	c := &opt.standaloneConfig
+	cmd.Flags().BoolVar(&opt.standalone, "standalone", opt.standalone, "Specify whether to run without Kubernetes, using the standalone flags or config file instead of the app binding")
+	cmd.Flags().StringVar(&opt.standaloneConfigFile, "config", opt.standaloneConfigFile, "Path of the standalone mode config file")
+	cmd.Flags().StringVar(&c.VaultAddress, "vault-address", c.VaultAddress, "API address of the VaultServer in standalone mode")
+	cmd.Flags().StringVar(&c.VaultCACert, "vault-ca-cert", c.VaultCACert, "Path of the CA certificate of the VaultServer in standalone mode")
+	cmd.Flags().StringVar(&c.VaultTokenFile, "vault-token-file", c.VaultTokenFile, "Path of the file holding the Vault token in standalone mode (VAULT_TOKEN env is used if empty)")
+	cmd.Flags().StringVar(&c.CredentialsDir, "credentials-dir", c.CredentialsDir, "Directory holding the repository credentials in standalone mode, one file per key")
+	cmd.Flags().StringVar(&c.KeyDir, "key-dir", c.KeyDir, "Directory holding the unseal keys & root token in standalone mode, one file per key")
+	cmd.Flags().IntVar(&c.SecretShares, "secret-shares", c.SecretShares, "Number of unseal keys of the VaultServer in standalone mode")
+	cmd.Flags().BoolVar(&c.OverwriteExisting, "overwrite-existing", c.OverwriteExisting, "Specify whether restore may overwrite the unseal keys & root token in the key directory in standalone mode")
+}
+
+// loadStandaloneConfig fills the standalone options that are not set by the flags from the config file
+func (opt *vaultOptions) loadStandaloneConfig() error {
+	c := &opt.standaloneConfig
+	if opt.standaloneConfigFile != "" {
+		data, err := os.ReadFile(opt.standaloneConfigFile)
+		if err != nil {
+			return err
+		}
+		file := standaloneConfig{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to decode %s. Reason: %w", opt.standaloneConfigFile, err)
+		}
+
+		setIfEmpty(&c.VaultAddress, file.VaultAddress)
+		setIfEmpty(&c.VaultCACert, file.VaultCACert)
+		setIfEmpty(&c.VaultTokenFile, file.VaultTokenFile)
+		setIfEmpty(&c.CredentialsDir, file.CredentialsDir)
+		setIfEmpty(&c.KeyDir, file.KeyDir)
+		if c.SecretShares == 0 {
+			c.SecretShares = file.SecretShares
+		}
+		c.OverwriteExisting = c.OverwriteExisting || file.OverwriteExisting
+		setIfEmpty(&opt.setupOptions.Provider, file.Repository.Provider)
+		setIfEmpty(&opt.setupOptions.Bucket, file.Repository.Bucket)
+		setIfEmpty(&opt.setupOptions.Endpoint, file.Repository.Endpoint)
+		setIfEmpty(&opt.setupOptions.Region, file.Repository.Region)
+		setIfEmpty(&opt.setupOptions.Path, file.Repository.Path)
+	}
+
+	if len(opt.secondaryStoreFiles) > 0 {
+		return fmt.Errorf("secondary stores are not supported in standalone mode")
+	}
+	if c.VaultAddress == "" && !opt.keysOnly {
+		return fmt.Errorf("vault address must be specified in standalone mode")
+	}
+	if opt.bundleFile == "" {
+		if opt.setupOptions.Provider == "" {
+			return fmt.Errorf("repository provider must be specified in standalone mode")
+		}
+		if c.CredentialsDir == "" {
+			return fmt.Errorf("credentials directory must be specified in standalone mode")
+		}
+	}
+	return nil
+}
+
+func setIfEmpty(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func (opt *vaultOptions) runStandaloneBackup() error {
+	if err := opt.loadStandaloneConfig(); err != nil {
+		return err
+	}
+
+	targetRef := api_v1beta1.TargetRef{
+		Name: opt.backupOptions.Host,
+	}
+	backupOutput, err := opt.backupVaultStandalone(targetRef)
+	if err != nil && backupOutput == nil {
+		backupOutput = opt.failedBackupOutput(targetRef, err)
+	}
+	// If output directory specified, then write the output in "output.json" file in the specified directory
+	if opt.outputDir != "" {
+		return backupOutput.WriteOutput(filepath.Join(opt.outputDir, restic.DefaultOutputFileName))
+	}
+	return err
+}
+
+func (opt *vaultOptions) runStandaloneRestore() error {
+	if err := opt.loadStandaloneConfig(); err != nil {
+		return err
+	}
+
+	targetRef := api_v1beta1.TargetRef{
+		Name: opt.restoreOptions.Host,
+	}
+	restoreOutput, err := opt.restoreVaultStandalone(targetRef)
+	if err != nil {
+		restoreOutput = opt.failedRestoreOutput(targetRef, err)
+	}
+	// If output directory specified, then write the output in "output.json" file in the specified directory
+	if opt.outputDir != "" {
+		return restoreOutput.WriteOutput(filepath.Join(opt.outputDir, restic.DefaultOutputFileName))
+	}
+	return err
+}
+
+func (opt *vaultOptions) backupVaultStandalone(targetRef api_v1beta1.TargetRef) (*BackupOutput, error) {
+	if err := opt.prepareStandaloneRepository(); err != nil {
+		return nil, err
+	}
+
+	if err := clearDir(opt.interimDataDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opt.setupOptions.ScratchDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	session := opt.newSessionWrapper(VaultCMD)
+	vaultClient, err := opt.connectStandaloneVault(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.waitForVaultReady(vaultClient, opt.waitTimeout); err != nil {
+		return nil, err
+	}
+	opt.setStandaloneLeader(session, vaultClient)
+
+	klog.Infof("Trying to backup for VaultServer %s\n", opt.standaloneConfig.VaultAddress)
+
+	vaultStats, skipped, err := opt.saveVaultData(resticWrapper, session, vaultClient, opt.standaloneTarget())
+	results := []vaultBackupResult{opt.newBackupResult(vaultStats, skipped, err)}
+	return opt.uploadVaultBackups(resticWrapper, targetRef, results, 1)
+}
+
+func (opt *vaultOptions) restoreVaultStandalone(targetRef api_v1beta1.TargetRef) (*RestoreOutput, error) {
+	// a bundle is restored without any repository
+	if opt.bundleFile == "" {
+		if err := opt.prepareStandaloneRepository(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := clearDir(opt.interimDataDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opt.setupOptions.ScratchDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	if opt.keysOnly {
+		return opt.restoreVaultKeys(opt.standaloneTarget(), targetRef)
+	}
+
+	session := opt.newSessionWrapper(VaultCMD)
+	vaultClient, err := opt.connectStandaloneVault(session)
+	if err != nil {
+		return nil, err
+	}
+
+	initialized, err := waitForVaultInitStatus(vaultClient, opt.waitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if initialized {
+		if err := session.waitForVaultReady(vaultClient, opt.waitTimeout); err != nil {
+			return nil, err
+		}
+		opt.setStandaloneLeader(session, vaultClient)
+	}
+
+	klog.Infof("Trying to restore snapshot for VaultServer %s\n", opt.standaloneConfig.VaultAddress)
+
+	return opt.restoreVaultData(session, vaultClient, initialized, opt.standaloneTarget(), targetRef)
+}
+
+// prepareStandaloneRepository reads the repository credentials from the local directory instead of the storage secret
+func (opt *vaultOptions) prepareStandaloneRepository() error {
+	var err error
+	opt.setupOptions.StorageSecret, err = secretFromDir(opt.standaloneConfig.CredentialsDir)
+	if err != nil {
+		return err
+	}
+
+	// apply nice, ionice settings from env
+	opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
+	if err != nil {
+		return err
+	}
+	opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
+	return err
+}
+
+// secretFromDir builds the storage secret from the files of the directory, the same way a secret is mounted as a volume
+func secretFromDir(dir string) (*core.Secret, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials. Reason: %w", err)
+	}
+
+	secret := &core.Secret{
+		Data: map[string][]byte{},
+	}
+	for _, entry := range entries {
+		// skip the hidden files & directories, i.e. ..data of a mounted secret
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secret.Data[entry.Name()] = data
+	}
+	return secret, nil
+}
+
+// connectStandaloneVault returns a client of the configured VaultServer, and sets the connection parameters of the vault command
+func (opt *vaultOptions) connectStandaloneVault(session *sessionWrapper) (*api.Client, error) {
+	c := opt.standaloneConfig
+
+	token := os.Getenv(EnvVaultToken)
+	if c.VaultTokenFile != "" {
+		data, err := os.ReadFile(c.VaultTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault token. Reason: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault token must be specified either by token file or by %s env in standalone mode", EnvVaultToken)
+	}
+
+	cfg := api.DefaultConfig()
+	cfg.Address = c.VaultAddress
+	if c.VaultCACert != "" {
+		if err := cfg.ConfigureTLS(&api.TLSConfig{CACert: c.VaultCACert}); err != nil {
+			return nil, err
+		}
+		session.sh.SetEnv(EnvVaultCACert, c.VaultCACert)
+	}
+
+	vaultClient, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	session.sh.SetEnv(EnvVaultAddress, c.VaultAddress)
+	session.sh.SetEnv(EnvVaultToken, token)
+	return vaultClient, nil
+}
+
+// setStandaloneLeader points the vault command to the API address of the leader, if the VaultServer reports one
+func (opt *vaultOptions) setStandaloneLeader(session *sessionWrapper, vc *api.Client) {
+	resp, err := vc.Sys().Leader()
+	if err != nil {
+		klog.Warningf("failed to find leader, %s will be used. Reason: %v", opt.standaloneConfig.VaultAddress, err)
+		return
+	}
+	if resp.LeaderAddress != "" {
+		session.sh.SetEnv(EnvVaultAddress, resp.LeaderAddress)
+	}
+}
+
+func (opt *vaultOptions) standaloneTarget() *vaultTarget {
+	keyDir := opt.standaloneConfig.KeyDir
+	return &vaultTarget{
+		unsealMode:        UnsealModeLocalFile,
+		secretShares:      opt.standaloneConfig.SecretShares,
+		overwriteExisting: opt.standaloneConfig.OverwriteExisting,
+		newStore: func() (store.StoreInterface, error) {
+			return local.New(keyDir)
+		},
+	}
+}
@@ -0,0 +1,28 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package all registers the stores of every unseal mode supported by the plugin
+package all
+
+import (
+	_ "stash.appscode.dev/vault/pkg/store/aws"
+	_ "stash.appscode.dev/vault/pkg/store/azure"
+	_ "stash.appscode.dev/vault/pkg/store/gcs"
+	_ "stash.appscode.dev/vault/pkg/store/k8s"
+	_ "stash.appscode.dev/vault/pkg/store/pkcs11"
+	_ "stash.appscode.dev/vault/pkg/store/plugin"
+	_ "stash.appscode.dev/vault/pkg/store/transit"
+)
@@ -0,0 +1,49 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// ArchivedKeyName returns the timestamped key the current value of the key is archived under
+func ArchivedKeyName(key string, now time.Time) string {
+	return fmt.Sprintf("%s-archived-%s", key, now.UTC().Format("20060102T150405Z"))
+}
+
+// Archive preserves the current value of the key before it is overwritten and returns how to roll it back.
+// Stores without native versioning get a copy of the value under a timestamped key.
+func Archive(st StoreInterface, key string, now time.Time) (string, error) {
+	return archive(st, key, ArchivedKeyName(key, now))
+}
+
+func archive(st StoreInterface, key, archived string) (string, error) {
+	if a, ok := st.(Archiver); ok {
+		return a.Archive(key, archived)
+	}
+
+	value, err := st.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := st.Set(archived, value); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("previous value of %s is archived as %s, copy it back to %s to roll back", key, archived, key), nil
+}
@@ -20,9 +20,15 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"os"
+	"sort"
+	"strings"
+
+	"stash.appscode.dev/vault/pkg/store"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -33,14 +39,74 @@ import (
 )
 
 const (
+	ModeAwsKmsSsm = "awsKmsSsm"
+
 	AWSAccessKey = "AWS_ACCESS_KEY_ID"
 	AWSSecretKey = "AWS_SECRET_ACCESS_KEY"
+
+	// keys of the credential secret
+	AccessKey    = "access_key"
+	SecretKey    = "secret_key"
+	SessionToken = "session_token"
+
+	// EncryptionContextTool is the KMS encryption context of the keys, shared with the unsealer
+	EncryptionContextTool = "vault-unsealer"
+
+	ParameterTypeString       = "String"
+	ParameterTypeSecureString = "SecureString"
+
+	DefaultRoleSessionName = "stash-vault"
 )
 
+func init() {
+	store.Register(ModeAwsKmsSsm, func(_ *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) bool {
+		return unsealerSpec.Mode.AwsKmsSsm != nil
+	}, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		return New(kc, appBinding, unsealerSpec.Mode.AwsKmsSsm)
+	})
+}
+
+// AwsOptions are the options of the awsKmsSsm mode missing from the vendored AwsKmsSsmSpec
+type AwsOptions struct {
+	// RoleARN is the IAM role to assume, with the web identity token if WebIdentityTokenFile is set
+	RoleARN string `json:"roleARN,omitempty"`
+	// ExternalID is passed when assuming RoleARN
+	ExternalID string `json:"externalID,omitempty"`
+	// RoleSessionName is the session name of the assumed role, defaults to stash-vault
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+	// WebIdentityTokenFile is the path of the web identity token to assume RoleARN with.
+	// IRSA works without it, as the AWS_ROLE_ARN & AWS_WEB_IDENTITY_TOKEN_FILE env set by EKS are used by default.
+	WebIdentityTokenFile string `json:"webIdentityTokenFile,omitempty"`
+	// SsmEndpoint is the endpoint of the SSM requests, i.e. a VPC endpoint. The endpoint of the unsealer spec is used for KMS.
+	SsmEndpoint string `json:"ssmEndpoint,omitempty"`
+	// ParameterPath is the path hierarchy the SSM parameters are kept under (i.e. /vault/prod), the parameters are named after the keys if empty.
+	// The unsealer reads the parameters by the key names only, so the parameters under a path are a backup-only copy
+	// that can't unseal Vault, and BackupOnly must be set to acknowledge it.
+	ParameterPath string `json:"parameterPath,omitempty"`
+	// BackupOnly acknowledges that the keys are kept where the unsealer does not read them
+	BackupOnly bool `json:"backupOnly,omitempty"`
+	// ParameterType is the type of the SSM parameters, String (default) or SecureString.
+	// The unsealer reads the parameters without decryption, so SecureString parameters are a backup-only copy as well.
+	ParameterType string `json:"parameterType,omitempty"`
+	// ParameterKmsKeyID is the KMS key SSM encrypts the SecureString parameters with, defaults to the aws/ssm key
+	ParameterKmsKeyID string `json:"parameterKmsKeyID,omitempty"`
+	// Tags are added to the SSM parameters
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func optionsFromAppBinding(appBinding *appcatalog.AppBinding) (*AwsOptions, error) {
+	opts := &AwsOptions{}
+	if _, err := store.DecodeModeParameters(appBinding, ModeAwsKmsSsm, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
 type awsKmsStore struct {
 	ssmService *ssm.SSM
 	kmsService *kms.KMS
 	awsSpec    *vaultapi.AwsKmsSsmSpec
+	options    *AwsOptions
 	appBinding *appcatalog.AppBinding
 }
 
@@ -57,68 +123,111 @@ func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, awsSpec *va
 		return nil, fmt.Errorf("kubeClient is nil")
 	}
 
-	var cred string
-	if awsSpec.CredentialSecretRef != nil {
-		cred = awsSpec.CredentialSecretRef.Name
-	}
-
-	secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), cred, metav1.GetOptions{})
+	options, err := optionsFromAppBinding(appBinding)
 	if err != nil {
 		return nil, err
 	}
+	if options.ParameterPath != "" && !options.BackupOnly {
+		return nil, fmt.Errorf("the unsealer does not read the keys under parameterPath %s, set backupOnly to keep a backup-only copy of the keys", options.ParameterPath)
+	}
+	if options.ParameterType == "" {
+		options.ParameterType = ParameterTypeString
+	}
+	if options.ParameterType != ParameterTypeString && options.ParameterType != ParameterTypeSecureString {
+		return nil, fmt.Errorf("unsupported parameter type %s", options.ParameterType)
+	}
+	if options.ParameterType == ParameterTypeSecureString && !options.BackupOnly {
+		return nil, fmt.Errorf("the unsealer does not decrypt %s parameters, set backupOnly to keep a backup-only copy of the keys", ParameterTypeSecureString)
+	}
 
-	accessKey, ok := secret.Data["access_key"]
-	if ok {
-		if err = os.Setenv(AWSAccessKey, string(accessKey)); err != nil {
-			return nil, err
-		}
+	config := &aws.Config{
+		CredentialsChainVerboseErrors: aws.Bool(true),
+		Region:                        aws.String(awsSpec.Region),
 	}
 
-	secretKey, ok := secret.Data["secret_key"]
-	if ok {
-		if err = os.Setenv(AWSSecretKey, string(secretKey)); err != nil {
+	// without a credential secret, the default chain is used, which covers IRSA, EKS pod identity & instance roles
+	if awsSpec.CredentialSecretRef != nil {
+		secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), awsSpec.CredentialSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
 			return nil, err
 		}
+
+		accessKey, secretKey := secret.Data[AccessKey], secret.Data[SecretKey]
+		if len(accessKey) > 0 || len(secretKey) > 0 {
+			config.Credentials = credentials.NewStaticCredentials(string(accessKey), string(secretKey), string(secret.Data[SessionToken]))
+		}
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		CredentialsChainVerboseErrors: func() *bool {
-			f := true
-			return &f
-		}(),
-		Region: aws.String(awsSpec.Region),
-	},
-	)
+	sess, err := session.NewSession(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
+	sessionName := options.RoleSessionName
+	if sessionName == "" {
+		sessionName = DefaultRoleSessionName
+	}
+	switch {
+	case options.WebIdentityTokenFile != "":
+		if options.RoleARN == "" {
+			return nil, fmt.Errorf("roleARN must be specified with webIdentityTokenFile")
+		}
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewWebIdentityCredentials(sess, options.RoleARN, sessionName, options.WebIdentityTokenFile),
+		})
+	case options.RoleARN != "":
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, options.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = sessionName
+				if options.ExternalID != "" {
+					p.ExternalID = aws.String(options.ExternalID)
+				}
+			}),
+		})
+	}
+
+	kmsConfig := &aws.Config{}
+	if awsSpec.Endpoint != "" {
+		kmsConfig.Endpoint = aws.String(awsSpec.Endpoint)
+	}
+	ssmConfig := &aws.Config{}
+	if options.SsmEndpoint != "" {
+		ssmConfig.Endpoint = aws.String(options.SsmEndpoint)
+	}
+
 	return &awsKmsStore{
-		kmsService: kms.New(sess),
-		ssmService: ssm.New(sess),
+		kmsService: kms.New(sess, kmsConfig),
+		ssmService: ssm.New(sess, ssmConfig),
 		awsSpec:    awsSpec,
+		options:    options,
 		appBinding: appBinding,
 	}, nil
 }
 
-func (store *awsKmsStore) Get(key string) (string, error) {
-	req := &ssm.GetParametersInput{
-		Names: []*string{
-			aws.String(key),
-		},
-		WithDecryption: aws.Bool(false),
+// name returns the name of the SSM parameter of the key, under the parameter path if any
+func (store *awsKmsStore) name(key string) string {
+	return store.pathPrefix() + key
+}
+
+func (store *awsKmsStore) pathPrefix() string {
+	p := strings.Trim(store.options.ParameterPath, "/")
+	if p == "" {
+		return ""
 	}
+	return "/" + p + "/"
+}
 
-	params, err := store.ssmService.GetParameters(req)
+func (store *awsKmsStore) Get(key string) (string, error) {
+	// the keys are read as the unsealer reads them, only the backup-only SecureString parameters are decrypted by SSM
+	out, err := store.ssmService.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(store.name(key)),
+		WithDecryption: aws.Bool(store.options.ParameterType == ParameterTypeSecureString),
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get key from ssm: %w", err)
 	}
 
-	if len(params.Parameters) == 0 {
-		return "", fmt.Errorf("failed to get key from ssm: empty response")
-	}
-
-	sDec, err := base64.StdEncoding.DecodeString(*params.Parameters[0].Value)
+	sDec, err := base64.StdEncoding.DecodeString(aws.StringValue(out.Parameter.Value))
 	if err != nil {
 		return "", fmt.Errorf("failed to base64-decode: %w", err)
 	}
@@ -126,7 +235,7 @@ func (store *awsKmsStore) Get(key string) (string, error) {
 	decryptOutput, err := store.kmsService.Decrypt(&kms.DecryptInput{
 		CiphertextBlob: sDec,
 		EncryptionContext: map[string]*string{
-			"Tool": aws.String("vault-unsealer"),
+			"Tool": aws.String(EncryptionContextTool),
 		},
 		GrantTokens: []*string{},
 		KeyId:       aws.String(store.awsSpec.KmsKeyID),
@@ -143,7 +252,7 @@ func (store *awsKmsStore) Set(key, value string) error {
 		KeyId:     aws.String(store.awsSpec.KmsKeyID),
 		Plaintext: []byte(value),
 		EncryptionContext: map[string]*string{
-			"Tool": aws.String("vault-unsealer"),
+			"Tool": aws.String(EncryptionContextTool),
 		},
 		GrantTokens: []*string{},
 	})
@@ -152,13 +261,130 @@ func (store *awsKmsStore) Set(key, value string) error {
 	}
 
 	req := &ssm.PutParameterInput{
-		Description: aws.String("vault-unsealer"),
-		Name:        aws.String(key),
+		Description: aws.String(EncryptionContextTool),
+		Name:        aws.String(store.name(key)),
 		Overwrite:   aws.Bool(true),
-		Type:        aws.String("String"),
+		Type:        aws.String(store.options.ParameterType),
 		Value:       aws.String(base64.StdEncoding.EncodeToString(out.CiphertextBlob)),
 	}
+	if store.options.ParameterType == ParameterTypeSecureString && store.options.ParameterKmsKeyID != "" {
+		req.KeyId = aws.String(store.options.ParameterKmsKeyID)
+	}
+
+	if _, err = store.ssmService.PutParameter(req); err != nil {
+		return err
+	}
+
+	// tags can't be given along with overwrite, so they are added separately
+	return store.tag(key)
+}
+
+func (store *awsKmsStore) tag(key string) error {
+	if len(store.options.Tags) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(store.options.Tags))
+	for k := range store.options.Tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	tags := make([]*ssm.Tag, 0, len(names))
+	for _, k := range names {
+		tags = append(tags, &ssm.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(store.options.Tags[k]),
+		})
+	}
+
+	_, err := store.ssmService.AddTagsToResource(&ssm.AddTagsToResourceInput{
+		ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
+		ResourceId:   aws.String(store.name(key)),
+		Tags:         tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag key %s: %w", key, err)
+	}
+	return nil
+}
+
+// List returns the keys starting with the prefix, without the parameter path.
+// Without a prefix or a parameter path, every parameter of the account would be described, so it is refused.
+func (store *awsKmsStore) List(prefix string) ([]string, error) {
+	name := store.name(prefix)
+	if name == "" {
+		return nil, fmt.Errorf("listing keys from ssm requires a key prefix or a parameter path")
+	}
+	req := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{
+				Key:    aws.String("Name"),
+				Option: aws.String("BeginsWith"),
+				Values: []*string{aws.String(name)},
+			},
+		},
+	}
+
+	var keys []string
+	err := store.ssmService.DescribeParametersPages(req, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, param := range page.Parameters {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(param.Name), store.pathPrefix()))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys from ssm: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (store *awsKmsStore) Delete(key string) error {
+	_, err := store.ssmService.DeleteParameter(&ssm.DeleteParameterInput{
+		Name: aws.String(store.name(key)),
+	})
+	if isParameterNotFound(err) {
+		return nil
+	}
 
-	_, err = store.ssmService.PutParameter(req)
 	return err
 }
+
+func (store *awsKmsStore) Exists(key string) (bool, error) {
+	_, err := store.ssmService.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(store.name(key)),
+		WithDecryption: aws.Bool(false),
+	})
+	if isParameterNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Archive relies on the parameter history of ssm, overwriting the parameter keeps the current value as its previous version.
+// The rollback restores the type of that version, which may differ from the configured one.
+func (store *awsKmsStore) Archive(key, _ string) (string, error) {
+	name := store.name(key)
+	out, err := store.ssmService.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(false),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get key from ssm: %w", err)
+	}
+
+	version := aws.Int64Value(out.Parameter.Version)
+	return fmt.Sprintf("previous value of %s is kept as version %d of the parameter, roll back with: "+
+		"aws ssm put-parameter --name %s --type %s --overwrite --value \"$(aws ssm get-parameter --with-decryption --name %s:%d --query Parameter.Value --output text)\"",
+		name, version, name, aws.StringValue(out.Parameter.Type), name, version), nil
+}
+
+func isParameterNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == ssm.ErrCodeParameterNotFound
+}
@@ -0,0 +1,107 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+func newTestStore(t *testing.T, options string) (*awsKmsStore, error) {
+	t.Helper()
+	appBinding := &appcatalog.AppBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"},
+	}
+	if options != "" {
+		appBinding.Spec.Parameters = &runtime.RawExtension{
+			Raw: []byte(`{"unsealer":{"mode":{"awsKmsSsm":` + options + `}}}`),
+		}
+	}
+	return New(fake.NewSimpleClientset(), appBinding, &vaultapi.AwsKmsSsmSpec{KmsKeyID: "key", Region: "us-east-1"})
+}
+
+func TestNewParameterPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  string
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "no parameter path",
+			wantName: "k8s.a-root-token",
+		},
+		{
+			// the unsealer would not find the keys under the path
+			name:    "parameter path without backupOnly",
+			options: `{"parameterPath":"/vault/prod"}`,
+			wantErr: true,
+		},
+		{
+			name:     "backup-only parameter path",
+			options:  `{"parameterPath":"vault/prod/","backupOnly":true}`,
+			wantName: "/vault/prod/k8s.a-root-token",
+		},
+		{
+			// the unsealer would read the ciphertext of SSM
+			name:    "secure string without backupOnly",
+			options: `{"parameterType":"SecureString"}`,
+			wantErr: true,
+		},
+		{
+			name:     "backup-only secure string",
+			options:  `{"parameterType":"SecureString","backupOnly":true}`,
+			wantName: "k8s.a-root-token",
+		},
+		{
+			name:    "unsupported parameter type",
+			options: `{"parameterType":"StringList"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st, err := newTestStore(t, c.options)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := st.name("k8s.a-root-token"); got != c.wantName {
+				t.Errorf("name() = %q, want %q", got, c.wantName)
+			}
+		})
+	}
+}
+
+func TestListRequiresPrefix(t *testing.T) {
+	st, err := newTestStore(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a prefix every parameter of the account would be described
+	if _, err := st.List(""); err == nil {
+		t.Error("List() without a prefix or a parameter path succeeded")
+	}
+}
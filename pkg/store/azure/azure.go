@@ -19,10 +19,14 @@ package azure
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
 	"strings"
 
+	"stash.appscode.dev/vault/pkg/store"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
 	"gomodules.xyz/pointer"
@@ -33,14 +37,50 @@ import (
 )
 
 const (
+	ModeAzureKeyVault = "azureKeyVault"
+
 	AzureClientID     = "AZURE_CLIENT_ID"
 	AzureClientSecret = "AZURE_CLIENT_SECRET"
 	AzureTenantID     = "AZURE_TENANT_ID"
+
+	// keys of the credential secret
+	ClientID     = "client-id"
+	ClientSecret = "client-secret"
+
+	// KeyNameEncodingLegacy replaces "." with "-" in the secret names, as the unsealer does. Distinct keys may collide.
+	KeyNameEncodingLegacy = "legacy"
+	// KeyNameEncodingReversible escapes "-" as "--", "." as "-d" and any other character not allowed in the secret names
+	// as "-x" followed by the hex of its bytes, after the "stashenc-" marker. Secrets named the legacy way are still read,
+	// and written along with the encoded ones, as the unsealer reads the keys by their legacy names.
+	KeyNameEncodingReversible = "reversible"
 )
 
+func init() {
+	store.Register(ModeAzureKeyVault, func(_ *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) bool {
+		return unsealerSpec.Mode.AzureKeyVault != nil
+	}, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		return New(kc, appBinding, unsealerSpec.Mode.AzureKeyVault)
+	})
+}
+
+// AzureOptions are the options of the azureKeyVault mode missing from the vendored AzureKeyVault spec
+type AzureOptions struct {
+	// KeyNameEncoding is the encoding of the keys into secret names, legacy (default) or reversible
+	KeyNameEncoding string `json:"keyNameEncoding,omitempty"`
+}
+
+func optionsFromAppBinding(appBinding *appcatalog.AppBinding) (*AzureOptions, error) {
+	opts := &AzureOptions{}
+	if _, err := store.DecodeModeParameters(appBinding, ModeAzureKeyVault, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
 type azureStore struct {
 	azureSpec  *vaultapi.AzureKeyVault
-	cred       *azidentity.DefaultAzureCredential
+	options    *AzureOptions
+	client     *azsecrets.Client
 	appBinding *appcatalog.AppBinding
 }
 
@@ -53,53 +93,80 @@ func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, azureSpec *
 		return nil, fmt.Errorf("appBinding is nil")
 	}
 
-	var cred string
-	if azureSpec.CredentialSecretRef != nil {
-		cred = azureSpec.CredentialSecretRef.Name
-	}
-	secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), cred, metav1.GetOptions{})
+	options, err := optionsFromAppBinding(appBinding)
 	if err != nil {
 		return nil, err
 	}
+	if options.KeyNameEncoding == "" {
+		options.KeyNameEncoding = KeyNameEncodingLegacy
+	}
+	if options.KeyNameEncoding != KeyNameEncodingLegacy && options.KeyNameEncoding != KeyNameEncodingReversible {
+		return nil, fmt.Errorf("unsupported key name encoding %s", options.KeyNameEncoding)
+	}
 
-	clientID, ok := secret.Data["client-id"]
-	if ok {
-		if err = os.Setenv(AzureClientID, string(clientID)); err != nil {
+	var clientID, clientSecret string
+	if azureSpec.CredentialSecretRef != nil {
+		if kc == nil {
+			return nil, fmt.Errorf("kubeClient is nil")
+		}
+		secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), azureSpec.CredentialSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
 			return nil, err
 		}
+		clientID, clientSecret = string(secret.Data[ClientID]), string(secret.Data[ClientSecret])
 	}
 
-	clientSecret, ok := secret.Data["client-secret"]
-	if ok {
-		if err = os.Setenv(AzureClientSecret, string(clientSecret)); err != nil {
-			return nil, err
+	var cred azcore.TokenCredential
+	switch {
+	case clientSecret != "":
+		cred, err = azidentity.NewClientSecretCredential(azureSpec.TenantID, clientID, clientSecret, nil)
+	case azureSpec.UseManagedIdentity:
+		// the client id selects a user-assigned identity, the system-assigned identity is used otherwise
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if clientID != "" {
+			opts.ID = azidentity.ClientID(clientID)
 		}
+		cred, err = azidentity.NewManagedIdentityCredential(opts)
+	default:
+		// covers AKS Workload Identity, through the env set by the workload identity webhook, and managed identity
+		cred, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			TenantID: azureSpec.TenantID,
+		})
 	}
-
-	if err := os.Setenv(AzureTenantID, azureSpec.TenantID); err != nil {
-		return nil, err
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure credential: %w", err)
 	}
 
-	azcred, err := azidentity.NewDefaultAzureCredential(nil)
+	client, err := azsecrets.NewClient(azureSpec.VaultBaseURL, cred, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	return &azureStore{
 		azureSpec:  azureSpec,
-		cred:       azcred,
+		options:    options,
+		client:     client,
 		appBinding: appBinding,
 	}, nil
 }
 
-func (store *azureStore) Get(key string) (string, error) {
-	vaultBaseUrl := store.azureSpec.VaultBaseURL
-	client, err := azsecrets.NewClient(vaultBaseUrl, store.cred, nil)
-	if err != nil {
-		return "", err
+func (store *azureStore) reversible() bool {
+	return store.options.KeyNameEncoding == KeyNameEncodingReversible
+}
+
+// secretName returns the name of the secret of the key
+func (store *azureStore) secretName(key string) string {
+	if store.reversible() {
+		return encodeKey(key)
 	}
+	return legacySecretName(key)
+}
 
-	resp, err := client.GetSecret(context.Background(), strings.Replace(key, ".", "-", -1), "", nil)
+func (store *azureStore) Get(key string) (string, error) {
+	resp, err := store.client.GetSecret(context.TODO(), store.secretName(key), "", nil)
+	if isNotFound(err) && store.reversible() {
+		resp, err = store.client.GetSecret(context.TODO(), legacySecretName(key), "", nil)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -113,15 +180,7 @@ func (store *azureStore) Get(key string) (string, error) {
 }
 
 func (store *azureStore) Set(key, value string) error {
-	key = strings.Replace(key, ".", "-", -1)
-
-	vaultBaseUrl := store.azureSpec.VaultBaseURL
-	client, err := azsecrets.NewClient(vaultBaseUrl, store.cred, nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = client.SetSecret(context.TODO(), key, azsecrets.SetSecretParameters{
+	_, err := store.client.SetSecret(context.TODO(), store.secretName(key), azsecrets.SetSecretParameters{
 		Value:       pointer.StringP(base64.StdEncoding.EncodeToString([]byte(value))),
 		ContentType: pointer.StringP("password"),
 	}, nil)
@@ -129,5 +188,118 @@ func (store *azureStore) Set(key, value string) error {
 		return fmt.Errorf("unable to set secrets in key vault: %w", err)
 	}
 
+	// the unsealer reads the secret named the legacy way, so it is kept up to date instead of being removed.
+	// Removing it would also leave a soft-deleted secret behind, that fails the next write of the unsealer.
+	if store.reversible() {
+		_, err = store.client.SetSecret(context.TODO(), legacySecretName(key), azsecrets.SetSecretParameters{
+			Value:       pointer.StringP(base64.StdEncoding.EncodeToString([]byte(value))),
+			ContentType: pointer.StringP("password"),
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("unable to set legacy secret of key %s in key vault: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// List returns the keys starting with the prefix. With the legacy encoding, the names are returned as stored in the key vault,
+// with "." replaced by "-". With the reversible encoding, the encoded names are decoded, and the secrets named the legacy way
+// are returned as stored, unless they are the legacy copy of a listed key.
+func (store *azureStore) List(prefix string) ([]string, error) {
+	var keys, legacyNames []string
+	pager := store.client.NewListSecretsPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("unable to list secrets in key vault: %w", err)
+		}
+		for _, secret := range page.Value {
+			if secret.ID == nil {
+				continue
+			}
+
+			name := secret.ID.Name()
+			if !store.reversible() {
+				if strings.HasPrefix(name, legacySecretName(prefix)) {
+					keys = append(keys, name)
+				}
+				continue
+			}
+
+			if key, err := decodeKey(name); err == nil {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			} else if strings.HasPrefix(name, legacySecretName(prefix)) {
+				legacyNames = append(legacyNames, name)
+			}
+		}
+	}
+
+	return append(keys, withoutLegacyCopies(keys, legacyNames)...), nil
+}
+
+// withoutLegacyCopies returns the legacy names that are not the legacy copy of any of the keys
+func withoutLegacyCopies(keys, legacyNames []string) []string {
+	copies := map[string]bool{}
+	for _, key := range keys {
+		copies[legacySecretName(key)] = true
+	}
+
+	var names []string
+	for _, name := range legacyNames {
+		if !copies[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Delete deletes the secret. With soft-delete enabled, the secret stays recoverable for the retention period of the key vault
+func (store *azureStore) Delete(key string) error {
+	if err := store.deleteSecret(store.secretName(key)); err != nil {
+		return fmt.Errorf("unable to delete secret from key vault: %w", err)
+	}
+	if store.reversible() && legacySecretName(key) != store.secretName(key) {
+		if err := store.deleteSecret(legacySecretName(key)); err != nil {
+			return fmt.Errorf("unable to delete secret from key vault: %w", err)
+		}
+	}
+
 	return nil
 }
+
+func (store *azureStore) deleteSecret(name string) error {
+	_, err := store.client.DeleteSecret(context.TODO(), name, nil)
+	if isNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (store *azureStore) Exists(key string) (bool, error) {
+	names := []string{store.secretName(key)}
+	if store.reversible() {
+		names = append(names, legacySecretName(key))
+	}
+
+	for _, name := range names {
+		_, err := store.client.GetSecret(context.TODO(), name, "", nil)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,94 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// legacySecretName is the secret name of the key as written by the unsealer
+func legacySecretName(key string) string {
+	return strings.Replace(key, ".", "-", -1)
+}
+
+// encodedNamePrefix marks the secret names written by encodeKey. Legacy names are made of alphanumerics & "-" too,
+// so without the marker a legacy name like k8s-a-d would be read as the escaped key k8s-a.
+// Only a key starting with "stashenc." or "stashenc-" has a legacy name that carries the marker.
+const encodedNamePrefix = "stashenc-"
+
+// encodeKey encodes the key into a secret name, which may only contain alphanumerics & "-".
+// Every escape has a fixed length, so the encoded prefix of a key is a prefix of the encoded key.
+func encodeKey(key string) string {
+	var sb strings.Builder
+	sb.WriteString(encodedNamePrefix)
+	for _, b := range []byte(key) {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+			sb.WriteByte(b)
+		case b == '-':
+			sb.WriteString("--")
+		case b == '.':
+			sb.WriteString("-d")
+		default:
+			sb.WriteString("-x")
+			sb.WriteString(hex.EncodeToString([]byte{b}))
+		}
+	}
+	return sb.String()
+}
+
+// decodeKey decodes the secret name written by encodeKey, the names without the marker are not decoded
+func decodeKey(name string) (string, error) {
+	if !strings.HasPrefix(name, encodedNamePrefix) {
+		return "", fmt.Errorf("%s is not an encoded key", name)
+	}
+	name = strings.TrimPrefix(name, encodedNamePrefix)
+
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '-' {
+			sb.WriteByte(name[i])
+			continue
+		}
+
+		if i+1 >= len(name) {
+			return "", fmt.Errorf("invalid escape at the end of %s", name)
+		}
+		i++
+		switch name[i] {
+		case '-':
+			sb.WriteByte('-')
+		case 'd':
+			sb.WriteByte('.')
+		case 'x':
+			if i+2 >= len(name) {
+				return "", fmt.Errorf("invalid escape at the end of %s", name)
+			}
+			b, err := hex.DecodeString(name[i+1 : i+3])
+			if err != nil {
+				return "", fmt.Errorf("invalid escape in %s: %w", name, err)
+			}
+			sb.Write(b)
+			i += 2
+		default:
+			return "", fmt.Errorf("invalid escape -%c in %s", name[i], name)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// secretNamePattern is the set of characters allowed in the key vault secret names
+var secretNamePattern = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+func TestEncodeKeyRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "alphanumerics", key: "roottoken", want: "stashenc-roottoken"},
+		{name: "dash", key: "a-root-token", want: "stashenc-a--root--token"},
+		{name: "dot", key: "k8s.a.b-unseal-key-0", want: "stashenc-k8s-da-db--unseal--key--0"},
+		{name: "dot and dash collide in the legacy name", key: "k8s-a.b", want: "stashenc-k8s--a-db"},
+		{name: "escaped characters", key: "a_b/c", want: "stashenc-a-x5fb-x2fc"},
+		{name: "looks like an escape", key: "a-d", want: "stashenc-a--d"},
+		{name: "double dash", key: "a--b", want: "stashenc-a----b"},
+		{name: "multibyte character", key: "ключ", want: "stashenc--xd0-xba-xd0-xbb-xd1-x8e-xd1-x87"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := encodeKey(c.key)
+			if got != c.want {
+				t.Errorf("encodeKey(%q) = %q, want %q", c.key, got, c.want)
+			}
+			if !secretNamePattern.MatchString(got) {
+				t.Errorf("encodeKey(%q) = %q is not a valid secret name", c.key, got)
+			}
+
+			key, err := decodeKey(got)
+			if err != nil {
+				t.Fatalf("decodeKey(%q) error = %v", got, err)
+			}
+			if key != c.key {
+				t.Errorf("decodeKey(%q) = %q, want %q", got, key, c.key)
+			}
+		})
+	}
+}
+
+func TestEncodeKeyPrefix(t *testing.T) {
+	// List filters the secrets by the encoded prefix, which must hold for every split of the key
+	key := "k8s.a_b-unseal-key-10"
+	for i := 0; i <= len(key); i++ {
+		if !strings.HasPrefix(encodeKey(key), encodeKey(key[:i])) {
+			t.Errorf("encodeKey(%q) is not a prefix of encodeKey(%q)", key[:i], key)
+		}
+	}
+}
+
+func TestDecodeKeyRejectsLegacyNames(t *testing.T) {
+	cases := []string{
+		// written by the unsealer, they would decode into other keys without the marker
+		"k8s-a-d",
+		"a--b",
+		"a-root-token",
+		"unseal-key-0",
+		// marked, but not a valid encoding
+		"stashenc-a-",
+		"stashenc-a-q",
+		"stashenc-a-x5",
+		"stashenc-a-xzz",
+	}
+
+	for _, name := range cases {
+		t.Run(name, func(t *testing.T) {
+			if key, err := decodeKey(name); err == nil {
+				t.Errorf("decodeKey(%q) = %q, want an error", name, key)
+			}
+		})
+	}
+}
+
+func TestWithoutLegacyCopies(t *testing.T) {
+	cases := []struct {
+		name        string
+		keys        []string
+		legacyNames []string
+		want        []string
+	}{
+		{
+			name:        "legacy copies are dropped",
+			keys:        []string{"k8s.a-root-token", "k8s.a-unseal-key-0"},
+			legacyNames: []string{"k8s-a-root-token", "k8s-a-unseal-key-0"},
+		},
+		{
+			name:        "keys only named the legacy way are kept",
+			keys:        []string{"k8s.a-root-token"},
+			legacyNames: []string{"k8s-a-root-token", "k8s-a-unseal-key-0"},
+			want:        []string{"k8s-a-unseal-key-0"},
+		},
+		{
+			name:        "no encoded keys",
+			legacyNames: []string{"k8s-a-root-token"},
+			want:        []string{"k8s-a-root-token"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := withoutLegacyCopies(c.keys, c.legacyNames)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("withoutLegacyCopies() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
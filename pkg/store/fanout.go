@@ -0,0 +1,168 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Member is a store of the fan-out store, the name identifies it in the logs & divergence reports
+type Member struct {
+	Name  string
+	Store StoreInterface
+}
+
+// FanoutStore mirrors the keys of a primary store into secondary stores.
+// Writes must succeed on the primary, only Get fails over to the secondaries in order.
+type FanoutStore struct {
+	members    []Member
+	divergence []string
+}
+
+var _ StoreInterface = &FanoutStore{}
+
+func NewFanoutStore(primary Member, secondaries ...Member) *FanoutStore {
+	return &FanoutStore{
+		members: append([]Member{primary}, secondaries...),
+	}
+}
+
+// Divergence returns the differences between the copies found so far, a missing or unreadable copy counts as a difference
+func (store *FanoutStore) Divergence() []string {
+	return store.divergence
+}
+
+func (store *FanoutStore) diverged(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	klog.Warningln(msg)
+	store.divergence = append(store.divergence, msg)
+}
+
+// Get reads the key from every store, so that diverged copies are reported, and returns the first value found
+func (store *FanoutStore) Get(key string) (string, error) {
+	var (
+		value string
+		found = -1
+		errs  []string
+	)
+
+	for i, m := range store.members {
+		v, err := m.Store.Get(key)
+		if err != nil {
+			store.diverged("key %s can't be read from store %s: %v", key, m.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", m.Name, err))
+			continue
+		}
+		if found < 0 {
+			value, found = v, i
+			continue
+		}
+		if v != value {
+			store.diverged("key %s in store %s differs from store %s", key, m.Name, store.members[found].Name)
+		}
+	}
+
+	if found < 0 {
+		return "", fmt.Errorf("failed to get key %s from any store: %s", key, strings.Join(errs, "; "))
+	}
+	if found > 0 {
+		klog.Warningf("key %s is read from store %s", key, store.members[found].Name)
+	}
+	return value, nil
+}
+
+// Set writes the key into every store, only a failure of the primary is an error
+func (store *FanoutStore) Set(key, value string) error {
+	if err := store.members[0].Store.Set(key, value); err != nil {
+		return err
+	}
+
+	for _, m := range store.members[1:] {
+		if err := m.Store.Set(key, value); err != nil {
+			store.diverged("key %s can't be written into store %s: %v", key, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// List returns the keys of the primary. Like Exists, it drives decisions to overwrite or delete keys,
+// so it does not fail over to the secondaries.
+func (store *FanoutStore) List(prefix string) ([]string, error) {
+	return store.members[0].Store.List(prefix)
+}
+
+// Delete deletes the key from every store, only a failure of the primary is an error
+func (store *FanoutStore) Delete(key string) error {
+	if err := store.members[0].Store.Delete(key); err != nil {
+		return err
+	}
+
+	for _, m := range store.members[1:] {
+		if err := m.Store.Delete(key); err != nil {
+			store.diverged("key %s can't be deleted from store %s: %v", key, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Exists reports whether the primary has the key. A secondary missing the key must not hide a key
+// the primary can't be checked for, as the key would then be overwritten without being archived.
+func (store *FanoutStore) Exists(key string) (bool, error) {
+	exists, err := store.members[0].Store.Exists(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check key %s in store %s: %w", key, store.members[0].Name, err)
+	}
+	return exists, nil
+}
+
+// Archive archives the key in every store that has it
+func (store *FanoutStore) Archive(key, archivedKey string) (string, error) {
+	var rollbacks []string
+	for _, m := range store.members {
+		exists, err := m.Store.Exists(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to check key %s in store %s: %w", key, m.Name, err)
+		}
+		if !exists {
+			continue
+		}
+
+		rollback, err := archive(m.Store, key, archivedKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to archive key %s in store %s: %w", key, m.Name, err)
+		}
+		rollbacks = append(rollbacks, fmt.Sprintf("%s: %s", m.Name, rollback))
+	}
+	return strings.Join(rollbacks, "; "), nil
+}
+
+// Close closes every store, a failure to close one store does not keep the others open
+func (store *FanoutStore) Close() error {
+	var errs []string
+	for _, m := range store.members {
+		if err := Close(m.Store); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close stores: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
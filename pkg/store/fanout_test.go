@@ -0,0 +1,312 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var errDown = errors.New("store is down")
+
+// memStore is an in-memory store, every operation fails while it is down
+type memStore struct {
+	data   map[string]string
+	down   bool
+	closed bool
+}
+
+func newMemStore(data map[string]string) *memStore {
+	m := &memStore{data: map[string]string{}}
+	for k, v := range data {
+		m.data[k] = v
+	}
+	return m
+}
+
+func (m *memStore) Get(key string) (string, error) {
+	if m.down {
+		return "", errDown
+	}
+	v, ok := m.data[key]
+	if !ok {
+		return "", fmt.Errorf("%s not found", key)
+	}
+	return v, nil
+}
+
+func (m *memStore) Set(key, value string) error {
+	if m.down {
+		return errDown
+	}
+	m.data[key] = value
+	return nil
+}
+
+func (m *memStore) List(prefix string) ([]string, error) {
+	if m.down {
+		return nil, errDown
+	}
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memStore) Delete(key string) error {
+	if m.down {
+		return errDown
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memStore) Exists(key string) (bool, error) {
+	if m.down {
+		return false, errDown
+	}
+	_, ok := m.data[key]
+	return ok, nil
+}
+
+func (m *memStore) Close() error {
+	m.closed = true
+	if m.down {
+		return errDown
+	}
+	return nil
+}
+
+func newTestFanout(primary, secondary *memStore) *FanoutStore {
+	return NewFanoutStore(Member{Name: "primary", Store: primary}, Member{Name: "secondary", Store: secondary})
+}
+
+func TestFanoutStoreGet(t *testing.T) {
+	cases := []struct {
+		name          string
+		primary       map[string]string
+		primaryDown   bool
+		secondary     map[string]string
+		secondaryDown bool
+		want          string
+		wantErr       bool
+		diverged      int
+	}{
+		{
+			name:      "same copies",
+			primary:   map[string]string{"k": "v"},
+			secondary: map[string]string{"k": "v"},
+			want:      "v",
+		},
+		{
+			name:      "diverged copies prefer the primary",
+			primary:   map[string]string{"k": "v1"},
+			secondary: map[string]string{"k": "v2"},
+			want:      "v1",
+			diverged:  1,
+		},
+		{
+			name:        "fail over to the secondary",
+			primaryDown: true,
+			secondary:   map[string]string{"k": "v"},
+			want:        "v",
+			diverged:    1,
+		},
+		{
+			name:      "missing in the secondary",
+			primary:   map[string]string{"k": "v"},
+			secondary: map[string]string{},
+			want:      "v",
+			diverged:  1,
+		},
+		{
+			name:          "missing everywhere",
+			primaryDown:   true,
+			secondaryDown: true,
+			wantErr:       true,
+			diverged:      2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			primary, secondary := newMemStore(c.primary), newMemStore(c.secondary)
+			primary.down, secondary.down = c.primaryDown, c.secondaryDown
+			st := newTestFanout(primary, secondary)
+
+			got, err := st.Get("k")
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("Get() = %q, want %q", got, c.want)
+			}
+			if len(st.Divergence()) != c.diverged {
+				t.Errorf("Divergence() = %q, want %d entries", st.Divergence(), c.diverged)
+			}
+		})
+	}
+}
+
+func TestFanoutStoreSet(t *testing.T) {
+	cases := []struct {
+		name          string
+		primaryDown   bool
+		secondaryDown bool
+		wantErr       bool
+		diverged      int
+	}{
+		{name: "all stores up"},
+		{name: "secondary down", secondaryDown: true, diverged: 1},
+		{name: "primary down", primaryDown: true, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			primary, secondary := newMemStore(nil), newMemStore(nil)
+			primary.down, secondary.down = c.primaryDown, c.secondaryDown
+			st := newTestFanout(primary, secondary)
+
+			err := st.Set("k", "v")
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if !c.primaryDown && primary.data["k"] != "v" {
+				t.Errorf("primary has %q, want %q", primary.data["k"], "v")
+			}
+			if len(st.Divergence()) != c.diverged {
+				t.Errorf("Divergence() = %q, want %d entries", st.Divergence(), c.diverged)
+			}
+		})
+	}
+}
+
+func TestFanoutStoreExists(t *testing.T) {
+	cases := []struct {
+		name        string
+		primary     map[string]string
+		primaryDown bool
+		secondary   map[string]string
+		want        bool
+		wantErr     bool
+	}{
+		{
+			name:      "in the primary",
+			primary:   map[string]string{"k": "v"},
+			secondary: map[string]string{},
+			want:      true,
+		},
+		{
+			name:      "only in the secondary",
+			primary:   map[string]string{},
+			secondary: map[string]string{"k": "v"},
+			want:      false,
+		},
+		{
+			// the key must not be reported absent, or it would be overwritten without being archived
+			name:        "primary down",
+			primaryDown: true,
+			secondary:   map[string]string{},
+			wantErr:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			primary, secondary := newMemStore(c.primary), newMemStore(c.secondary)
+			primary.down = c.primaryDown
+			st := newTestFanout(primary, secondary)
+
+			got, err := st.Exists("k")
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Exists() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("Exists() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFanoutStoreListUsesPrimary(t *testing.T) {
+	primary := newMemStore(map[string]string{"a-0": "v"})
+	secondary := newMemStore(map[string]string{"a-0": "v", "a-1": "v"})
+	st := newTestFanout(primary, secondary)
+
+	keys, err := st.List("a-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "a-0" {
+		t.Errorf("List() = %q, want [a-0]", keys)
+	}
+
+	primary.down = true
+	if _, err := st.List("a-"); err == nil {
+		t.Error("List() with the primary down succeeded")
+	}
+}
+
+func TestFanoutStoreArchive(t *testing.T) {
+	primary := newMemStore(map[string]string{"k": "v1"})
+	secondary := newMemStore(map[string]string{})
+	st := newTestFanout(primary, secondary)
+
+	if _, err := st.Archive("k", "k-archived"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.data["k-archived"] != "v1" {
+		t.Errorf("primary archived %q, want %q", primary.data["k-archived"], "v1")
+	}
+	if _, ok := secondary.data["k-archived"]; ok {
+		t.Error("secondary without the key got an archived copy")
+	}
+}
+
+func TestFanoutStoreClose(t *testing.T) {
+	cases := []struct {
+		name        string
+		primaryDown bool
+		wantErr     bool
+	}{
+		{name: "all stores up"},
+		{name: "primary fails to close", primaryDown: true, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			primary, secondary := newMemStore(nil), newMemStore(nil)
+			primary.down = c.primaryDown
+			st := newTestFanout(primary, secondary)
+
+			err := Close(st)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Close() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if !primary.closed || !secondary.closed {
+				t.Errorf("closed primary %v, secondary %v, want both closed", primary.closed, secondary.closed)
+			}
+		})
+	}
+}
@@ -18,18 +18,17 @@ package gcs
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"os"
-	"path/filepath"
+
+	"stash.appscode.dev/vault/pkg/store"
 
 	kmsv1 "cloud.google.com/go/kms/apiv1"
 	"cloud.google.com/go/kms/apiv1/kmspb"
 	"cloud.google.com/go/storage"
-	passgen "gomodules.xyz/password-generator"
-	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,13 +38,23 @@ import (
 )
 
 const (
-	ServiceAccountJSON    = "sa.json"
-	GoogleApplicationCred = "GOOGLE_APPLICATION_CREDENTIALS"
+	ModeGoogleKmsGcs = "googleKmsGcs"
+
+	ServiceAccountJSON = "sa.json"
 )
 
+func init() {
+	store.Register(ModeGoogleKmsGcs, func(_ *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) bool {
+		return unsealerSpec.Mode.GoogleKmsGcs != nil
+	}, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		return New(kc, appBinding, unsealerSpec.Mode.GoogleKmsGcs)
+	})
+}
+
 type gcsStore struct {
 	gcsSpec    *vaultapi.GoogleKmsGcsSpec
 	client     *storage.Client
+	kmsClient  *kmsv1.KeyManagementClient
 	appBinding *appcatalog.AppBinding
 }
 
@@ -62,46 +71,58 @@ func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, gcsSpec *va
 		return nil, fmt.Errorf("kubeClient is nil")
 	}
 
-	var cred string
+	// without a credential secret, the application default credentials are used, which covers GKE Workload Identity
+	var opts []option.ClientOption
 	if gcsSpec.CredentialSecretRef != nil {
-		cred = gcsSpec.CredentialSecretRef.Name
+		secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), gcsSpec.CredentialSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		saJSON, ok := secret.Data[ServiceAccountJSON]
+		if !ok {
+			return nil, fmt.Errorf("%s not found in secret", ServiceAccountJSON)
+		}
+		opts = append(opts, option.WithCredentialsJSON(saJSON))
 	}
 
-	secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), cred, metav1.GetOptions{})
+	client, err := storage.NewClient(context.TODO(), opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := secret.Data[ServiceAccountJSON]; !ok {
-		return nil, fmt.Errorf("%s not found in secret", ServiceAccountJSON)
-	}
-
-	path := filepath.Join("/tmp", fmt.Sprintf("google-sa-cred-%s", passgen.Generate(6)))
-	if err = os.MkdirAll(path, os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	saFile := filepath.Join(path, ServiceAccountJSON)
-	if err = os.WriteFile(saFile, secret.Data[ServiceAccountJSON], os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	if err = os.Setenv(GoogleApplicationCred, saFile); err != nil {
-		return nil, err
-	}
-
-	client, err := storage.NewClient(context.TODO())
+	kmsClient, err := kmsv1.NewKeyManagementClient(context.TODO(), opts...)
 	if err != nil {
-		return nil, err
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to create kms client: %w", err)
 	}
 
 	return &gcsStore{
 		gcsSpec:    gcsSpec,
 		client:     client,
+		kmsClient:  kmsClient,
 		appBinding: appBinding,
 	}, nil
 }
 
+// Close closes the storage & kms clients, along with their connections
+func (store *gcsStore) Close() error {
+	kmsErr := store.kmsClient.Close()
+	if err := store.client.Close(); err != nil {
+		return fmt.Errorf("failed to close gcs client: %w", err)
+	}
+	if kmsErr != nil {
+		return fmt.Errorf("failed to close kms client: %w", kmsErr)
+	}
+	return nil
+}
+
+func (store *gcsStore) cryptoKeyName() string {
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
+		store.gcsSpec.KmsProject, store.gcsSpec.KmsLocation,
+		store.gcsSpec.KmsKeyRing, store.gcsSpec.KmsCryptoKey)
+}
+
 func (store *gcsStore) Get(key string) (string, error) {
 	rc, err := store.client.Bucket(store.gcsSpec.Bucket).Object(key).NewReader(context.TODO())
 	if err != nil {
@@ -109,78 +130,140 @@ func (store *gcsStore) Get(key string) (string, error) {
 	}
 	defer rc.Close()
 
+	// the reader verifies the crc32c of the object while reading it to the end
 	body, err := io.ReadAll(rc)
 	if err != nil {
 		return "", err
 	}
 
-	name := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
-		store.gcsSpec.KmsProject, store.gcsSpec.KmsLocation,
-		store.gcsSpec.KmsKeyRing, store.gcsSpec.KmsCryptoKey)
+	return store.decryptSymmetric(body)
+}
 
-	decryptedToken, err := decryptSymmetric(name, body)
+func (store *gcsStore) Set(key, value string) error {
+	cipherText, err := store.encryptSymmetric([]byte(value))
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	w := store.client.Bucket(store.gcsSpec.Bucket).Object(key).NewWriter(context.TODO())
+	// gcs rejects the upload if the object does not match the checksum
+	w.CRC32C = crc32c(cipherText)
+	w.SendCRC32C = true
+	if _, err := w.Write(cipherText); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("error writing key %s to gcs bucket %s: %w", key, store.gcsSpec.Bucket, err)
 	}
 
-	return decryptedToken, nil
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing key %s to gcs bucket %s: %w", key, store.gcsSpec.Bucket, err)
+	}
+	return nil
 }
 
-func (store *gcsStore) Set(key, value string) error {
-	kmsService, err := cloudkms.NewService(context.TODO(), option.WithScopes(cloudkms.CloudPlatformScope))
-	if err != nil {
-		return fmt.Errorf("error creating google kms service client: %w", err)
+func (store *gcsStore) List(prefix string) ([]string, error) {
+	it := store.client.Bucket(store.gcsSpec.Bucket).Objects(context.TODO(), &storage.Query{Prefix: prefix})
+
+	var keys []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing keys from gcs bucket %s: %w", store.gcsSpec.Bucket, err)
+		}
+		keys = append(keys, attrs.Name)
 	}
 
-	name := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
-		store.gcsSpec.KmsProject, store.gcsSpec.KmsLocation,
-		store.gcsSpec.KmsKeyRing, store.gcsSpec.KmsCryptoKey)
+	return keys, nil
+}
+
+func (store *gcsStore) Delete(key string) error {
+	err := store.client.Bucket(store.gcsSpec.Bucket).Object(key).Delete(context.TODO())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+
+	return err
+}
 
-	resp, err := kmsService.Projects.Locations.KeyRings.CryptoKeys.Encrypt(name, &cloudkms.EncryptRequest{
-		Plaintext: base64.StdEncoding.EncodeToString([]byte(value)),
-	}).Do()
+func (store *gcsStore) Exists(key string) (bool, error) {
+	_, err := store.client.Bucket(store.gcsSpec.Bucket).Object(key).Attrs(context.TODO())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error encrypting data: %w", err)
+		return false, err
 	}
 
-	cipherText, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	return true, nil
+}
+
+// Archive relies on the object generations when the bucket has versioning enabled, otherwise the object is copied to a timestamped key
+func (store *gcsStore) Archive(key, archived string) (string, error) {
+	bucket := store.client.Bucket(store.gcsSpec.Bucket)
+
+	bucketAttrs, err := bucket.Attrs(context.TODO())
 	if err != nil {
-		return err
+		return "", fmt.Errorf("error reading attributes of gcs bucket %s: %w", store.gcsSpec.Bucket, err)
 	}
 
-	w := store.client.Bucket(store.gcsSpec.Bucket).Object(key).NewWriter(context.TODO())
-	if _, err := w.Write(cipherText); err != nil {
-		return fmt.Errorf("error writing key %s to gcs bucket %s: %w", key, store.gcsSpec.Bucket, err)
+	if bucketAttrs.VersioningEnabled {
+		attrs, err := bucket.Object(key).Attrs(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("previous value of %s is kept as generation %d of the object, roll back with: gsutil cp gs://%s/%s#%d gs://%s/%s",
+			key, attrs.Generation, store.gcsSpec.Bucket, key, attrs.Generation, store.gcsSpec.Bucket, key), nil
 	}
 
-	return w.Close()
+	// the ciphertext is copied as is, it stays encrypted with the same kms key
+	if _, err := bucket.Object(archived).CopierFrom(bucket.Object(key)).Run(context.TODO()); err != nil {
+		return "", fmt.Errorf("error archiving key %s in gcs bucket %s: %w", key, store.gcsSpec.Bucket, err)
+	}
+	return fmt.Sprintf("previous value of %s is archived as %s, roll back with: gsutil cp gs://%s/%s gs://%s/%s",
+		key, archived, store.gcsSpec.Bucket, archived, store.gcsSpec.Bucket, key), nil
 }
 
-func decryptSymmetric(name string, ciphertext []byte) (string, error) {
-	client, err := kmsv1.NewKeyManagementClient(context.TODO())
+func crc32c(data []byte) uint32 {
+	return crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+}
+
+func (store *gcsStore) encryptSymmetric(plaintext []byte) ([]byte, error) {
+	req := &kmspb.EncryptRequest{
+		Name:            store.cryptoKeyName(),
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(int64(crc32c(plaintext))),
+	}
+
+	result, err := store.kmsClient.Encrypt(context.TODO(), req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create kms client: %w", err)
+		return nil, fmt.Errorf("error encrypting data: %w", err)
 	}
-	defer client.Close()
 
-	crc32c := func(data []byte) uint32 {
-		t := crc32.MakeTable(crc32.Castagnoli)
-		return crc32.Checksum(data, t)
+	if !result.VerifiedPlaintextCrc32C {
+		return nil, fmt.Errorf("encrypt request corrupted in-transit")
 	}
-	ciphertextCRC32C := crc32c(ciphertext)
+	if result.CiphertextCrc32C == nil || int64(crc32c(result.Ciphertext)) != result.CiphertextCrc32C.Value {
+		return nil, fmt.Errorf("encrypt response corrupted in-transit")
+	}
+
+	return result.Ciphertext, nil
+}
 
+func (store *gcsStore) decryptSymmetric(ciphertext []byte) (string, error) {
 	req := &kmspb.DecryptRequest{
-		Name:             name,
+		Name:             store.cryptoKeyName(),
 		Ciphertext:       ciphertext,
-		CiphertextCrc32C: wrapperspb.Int64(int64(ciphertextCRC32C)),
+		CiphertextCrc32C: wrapperspb.Int64(int64(crc32c(ciphertext))),
 	}
 
-	result, err := client.Decrypt(context.TODO(), req)
+	result, err := store.kmsClient.Decrypt(context.TODO(), req)
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt ciphertext: %w", err)
 	}
 
-	if int64(crc32c(result.Plaintext)) != result.PlaintextCrc32C.Value {
+	if result.PlaintextCrc32C == nil || int64(crc32c(result.Plaintext)) != result.PlaintextCrc32C.Value {
 		return "", fmt.Errorf("decrypt response corrupted in-transit")
 	}
 
@@ -16,7 +16,29 @@ limitations under the License.
 
 package store
 
+import "io"
+
 type StoreInterface interface {
 	Get(key string) (string, error)
 	Set(key, value string) error
+	// List returns the keys that start with the given prefix
+	List(prefix string) ([]string, error)
+	// Delete removes the key, deleting a key that does not exist is not an error
+	Delete(key string) error
+	Exists(key string) (bool, error)
+}
+
+// Archiver is implemented by the stores that keep the previous value of a key natively when it is overwritten
+type Archiver interface {
+	// Archive preserves the current value of the key and returns how to roll it back.
+	// archivedKey is the timestamped key to use if the value has to be copied.
+	Archive(key, archivedKey string) (string, error)
+}
+
+// Close releases the clients held by the store, for the stores that keep any open
+func Close(st StoreInterface) error {
+	if c, ok := st.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
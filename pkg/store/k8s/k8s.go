@@ -19,8 +19,13 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+
+	"stash.appscode.dev/vault/pkg/store"
 
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	core_util "kmodules.xyz/client-go/core/v1"
@@ -28,6 +33,16 @@ import (
 	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
 )
 
+const ModeKubernetesSecret = "kubernetesSecret"
+
+func init() {
+	store.Register(ModeKubernetesSecret, func(_ *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) bool {
+		return unsealerSpec.Mode.KubernetesSecret != nil
+	}, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		return New(kc, appBinding, unsealerSpec.Mode.KubernetesSecret)
+	})
+}
+
 type k8sStore struct {
 	k8sSpec    *vaultapi.KubernetesSecretSpec
 	kc         kubernetes.Interface
@@ -82,3 +97,57 @@ func (store *k8sStore) Set(key, value string) error {
 
 	return err
 }
+
+func (store *k8sStore) List(prefix string) ([]string, error) {
+	secret, err := store.kc.CoreV1().Secrets(store.appBinding.Namespace).Get(context.TODO(), store.k8sSpec.SecretName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range secret.Data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (store *k8sStore) Delete(key string) error {
+	secret, err := store.kc.CoreV1().Secrets(store.appBinding.Namespace).Get(context.TODO(), store.k8sSpec.SecretName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secret.Data[key]; !ok {
+		return nil
+	}
+
+	_, _, err = core_util.PatchSecret(context.TODO(), store.kc, secret, func(s *corev1.Secret) *corev1.Secret {
+		delete(s.Data, key)
+		return s
+	}, metav1.PatchOptions{})
+
+	return err
+}
+
+func (store *k8sStore) Exists(key string) (bool, error) {
+	secret, err := store.kc.CoreV1().Secrets(store.appBinding.Namespace).Get(context.TODO(), store.k8sSpec.SecretName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := secret.Data[key]
+	return ok, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localStore keeps each unseal key & root token in a file of a local directory, named after the key
+type localStore struct {
+	dir string
+}
+
+func New(dir string) (*localStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("key directory is empty")
+	}
+
+	return &localStore{
+		dir: dir,
+	}, nil
+}
+
+func (store *localStore) Get(key string) (string, error) {
+	data, err := os.ReadFile(store.path(key))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (store *localStore) Set(key, value string) error {
+	if err := os.MkdirAll(store.dir, 0o700); err != nil {
+		return err
+	}
+
+	// write into a temporary file first, so that an interrupted write never leaves a partial key behind
+	tmp, err := os.CreateTemp(store.dir, "."+filepath.Base(key)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(value); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.path(key))
+}
+
+func (store *localStore) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		// skip the temporary files of interrupted writes
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), prefix) {
+			keys = append(keys, entry.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+func (store *localStore) Delete(key string) error {
+	err := os.Remove(store.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (store *localStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(store.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (store *localStore) path(key string) string {
+	return filepath.Join(store.dir, filepath.Base(key))
+}
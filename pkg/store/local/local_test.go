@@ -0,0 +1,145 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	st, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory is created on the first write
+	if keys, err := st.List(""); err != nil || len(keys) != 0 {
+		t.Fatalf("List() on a missing directory = %q, %v", keys, err)
+	}
+
+	for key, value := range map[string]string{
+		"k8s.a-root-token":   "token",
+		"k8s.a-unseal-key-0": "key-0",
+		"k8s.b-unseal-key-0": "other",
+	} {
+		if err := st.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a temporary file left behind by an interrupted write
+	if err := os.WriteFile(filepath.Join(dir, ".k8s.a-unseal-key-1-123"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{name: "all keys", prefix: "", want: []string{"k8s.a-root-token", "k8s.a-unseal-key-0", "k8s.b-unseal-key-0"}},
+		{name: "with prefix", prefix: "k8s.a-", want: []string{"k8s.a-root-token", "k8s.a-unseal-key-0"}},
+		{name: "no match", prefix: "k8s.c-"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keys, err := st.List(c.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, c.want) {
+				t.Errorf("List(%q) = %q, want %q", c.prefix, keys, c.want)
+			}
+		})
+	}
+}
+
+func TestLocalStoreGetSet(t *testing.T) {
+	cases := []struct {
+		name  string
+		key   string
+		value string
+		want  string
+	}{
+		{name: "value", key: "root-token", value: "token", want: "token"},
+		// keys written by hand often end with a newline
+		{name: "trailing newline", key: "unseal-key-0", value: "key\n", want: "key"},
+		{name: "overwrite", key: "root-token", value: "new-token", want: "new-token"},
+		// the key names a file of the directory only
+		{name: "key with a path", key: "../unseal-key-1", value: "key-1", want: "key-1"},
+	}
+
+	dir := t.TempDir()
+	st, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := st.Set(c.key, c.value); err != nil {
+				t.Fatal(err)
+			}
+			got, err := st.Get(c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("Get(%q) = %q, want %q", c.key, got, c.want)
+			}
+			if exists, err := st.Exists(c.key); err != nil || !exists {
+				t.Errorf("Exists(%q) = %v, %v, want true", c.key, exists, err)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "unseal-key-1")); !os.IsNotExist(err) {
+		t.Errorf("key was written outside the directory: %v", err)
+	}
+}
+
+func TestLocalStoreDelete(t *testing.T) {
+	st, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Set("root-token", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"root-token", "root-token", "missing"} {
+		if err := st.Delete(key); err != nil {
+			t.Errorf("Delete(%q) error = %v", key, err)
+		}
+	}
+	if exists, err := st.Exists("root-token"); err != nil || exists {
+		t.Errorf("Exists() after Delete() = %v, %v, want false", exists, err)
+	}
+	if _, err := st.Get("root-token"); err == nil {
+		t.Error("Get() after Delete() succeeded")
+	}
+}
+
+func TestNewRequiresDirectory(t *testing.T) {
+	if _, err := New(""); err == nil {
+		t.Error("New() without a directory succeeded")
+	}
+}
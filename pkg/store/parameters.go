@@ -0,0 +1,77 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+// The vendored VaultServerConfiguration only knows about the upstream unseal modes & their upstream fields.
+// The stores read their other options, and the modes the vendored unsealer spec does not know about,
+// from the unsealer mode of the app binding parameters, i.e. {"unsealer":{"mode":{"vaultTransit":{...}}}}.
+
+// modeParameters returns the unsealer modes of the app binding parameters by name
+func modeParameters(appBinding *appcatalog.AppBinding) (map[string]json.RawMessage, error) {
+	if appBinding == nil || appBinding.Spec.Parameters == nil {
+		return nil, nil
+	}
+
+	params := struct {
+		Unsealer *struct {
+			Mode map[string]json.RawMessage `json:"mode"`
+		} `json:"unsealer,omitempty"`
+	}{}
+	if err := json.Unmarshal(appBinding.Spec.Parameters.Raw, &params); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal appBinding.Spec.Parameters.Raw: %w", err)
+	}
+	if params.Unsealer == nil {
+		return nil, nil
+	}
+	return params.Unsealer.Mode, nil
+}
+
+// DecodeModeParameters decodes the unsealer mode of the app binding parameters into out.
+// It reports whether the parameters specify the mode.
+func DecodeModeParameters(appBinding *appcatalog.AppBinding, mode string, out interface{}) (bool, error) {
+	modes, err := modeParameters(appBinding)
+	if err != nil {
+		return false, err
+	}
+
+	raw, ok := modes[mode]
+	if !ok || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return false, fmt.Errorf("unable to unmarshal unsealer mode %s of appBinding.Spec.Parameters.Raw: %w", mode, err)
+	}
+	return true, nil
+}
+
+// RegisterParametersMode registers the store of an unseal mode that is selected by the unsealer mode of the app binding parameters
+func RegisterParametersMode(mode string, factory Factory) {
+	Register(mode, func(appBinding *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) bool {
+		var spec json.RawMessage
+		ok, _ := DecodeModeParameters(appBinding, mode, &spec)
+		return ok
+	}, factory)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+func TestDecodeModeParameters(t *testing.T) {
+	type options struct {
+		Address string `json:"address"`
+	}
+
+	cases := []struct {
+		name       string
+		parameters string
+		wantOK     bool
+		want       options
+		wantErr    bool
+	}{
+		{name: "no parameters"},
+		{name: "no unsealer", parameters: `{"backend":{"raft":{}}}`},
+		{name: "other mode", parameters: `{"unsealer":{"mode":{"kubernetesSecret":{"secretName":"keys"}}}}`},
+		{name: "null mode", parameters: `{"unsealer":{"mode":{"test":null}}}`},
+		{
+			name:       "mode",
+			parameters: `{"unsealer":{"mode":{"test":{"address":"https://vault:8200"}}}}`,
+			wantOK:     true,
+			want:       options{Address: "https://vault:8200"},
+		},
+		{name: "invalid mode", parameters: `{"unsealer":{"mode":{"test":"address"}}}`, wantErr: true},
+		{name: "invalid parameters", parameters: `{"unsealer":`, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			appBinding := &appcatalog.AppBinding{}
+			if c.parameters != "" {
+				appBinding.Spec.Parameters = &runtime.RawExtension{Raw: []byte(c.parameters)}
+			}
+
+			got := options{}
+			ok, err := DecodeModeParameters(appBinding, "test", &got)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("DecodeModeParameters() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if ok != c.wantOK || got != c.want {
+				t.Errorf("DecodeModeParameters() = %v, %+v, want %v, %+v", ok, got, c.wantOK, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+//go:build cgo && pkcs11
+
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"stash.appscode.dev/vault/pkg/store"
+	"stash.appscode.dev/vault/pkg/store/k8s"
+
+	p11 "github.com/miekg/pkcs11"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+const (
+	// ciphertextPrefix versions the format of the ciphertext, which is the base64 of the AES-GCM nonce followed by the sealed key
+	ciphertextPrefix = "pkcs11:v1:"
+
+	gcmIVSize  = 12
+	gcmTagSize = 16
+)
+
+// module is a loaded PKCS#11 module. A module can be initialized once per process, so the modules are shared.
+type module struct {
+	path string
+	ctx  *p11.Ctx
+	refs int
+}
+
+var (
+	modulesLock sync.Mutex
+	modules     = map[string]*module{}
+)
+
+func loadModule(path string) (*module, error) {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	if m, ok := modules[path]; ok {
+		m.refs++
+		return m, nil
+	}
+
+	ctx := p11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load pkcs11 module %s", path)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, p11.Error(p11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize pkcs11 module %s: %w", path, err)
+	}
+
+	m := &module{path: path, ctx: ctx, refs: 1}
+	modules[path] = m
+	return m, nil
+}
+
+// release finalizes & unloads the module once it is no longer used
+func (m *module) release() error {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	m.refs--
+	if m.refs > 0 {
+		return nil
+	}
+	delete(modules, m.path)
+
+	err := m.ctx.Finalize()
+	m.ctx.Destroy()
+	return err
+}
+
+// openSession opens a session of the token in the slot and logs the user in
+func (m *module) openSession(slot uint, pin string) (p11.SessionHandle, error) {
+	session, err := m.ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.ctx.Login(session, p11.CKU_USER, pin); err != nil && !errors.Is(err, p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = m.ctx.CloseSession(session)
+		return 0, err
+	}
+	return session, nil
+}
+
+// findKey returns the only AES key with the label
+func (m *module) findKey(session p11.SessionHandle, label string) (p11.ObjectHandle, error) {
+	template := []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, p11.CKO_SECRET_KEY),
+		p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_AES),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+	}
+	if err := m.ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+	keys, _, err := m.ctx.FindObjects(session, 2)
+	if ferr := m.ctx.FindObjectsFinal(session); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch len(keys) {
+	case 0:
+		return 0, fmt.Errorf("aes key %s not found in the token", label)
+	case 1:
+		return keys[0], nil
+	}
+	return 0, fmt.Errorf("token has more than one aes key with label %s", label)
+}
+
+type pkcs11Store struct {
+	spec *PKCS11Spec
+	// lock serializes the operations of the session, as a PKCS#11 session may run one operation at a time
+	lock    sync.Mutex
+	module  *module
+	session p11.SessionHandle
+	key     p11.ObjectHandle
+	// ciphertext keeps the ciphertext of the keys
+	ciphertext store.StoreInterface
+}
+
+func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, spec *PKCS11Spec) (store.StoreInterface, error) {
+	if err := validateSpec(kc, appBinding, spec); err != nil {
+		return nil, err
+	}
+
+	secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), spec.CredentialSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{ModulePathKey, SlotKey, PINKey} {
+		if _, ok := secret.Data[k]; !ok {
+			return nil, fmt.Errorf("%s not found in secret", k)
+		}
+	}
+	slot, err := strconv.ParseUint(strings.TrimSpace(string(secret.Data[SlotKey])), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slot %q: %w", secret.Data[SlotKey], err)
+	}
+
+	ciphertext, err := k8s.New(kc, appBinding, &vaultapi.KubernetesSecretSpec{SecretName: spec.SecretName})
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := loadModule(strings.TrimSpace(string(secret.Data[ModulePathKey])))
+	if err != nil {
+		return nil, err
+	}
+	session, err := m.openSession(uint(slot), strings.TrimSpace(string(secret.Data[PINKey])))
+	if err != nil {
+		_ = m.release()
+		return nil, fmt.Errorf("failed to open session of slot %d: %w", slot, err)
+	}
+	key, err := m.findKey(session, spec.KeyLabel)
+	if err != nil {
+		_ = m.ctx.CloseSession(session)
+		_ = m.release()
+		return nil, err
+	}
+
+	return &pkcs11Store{
+		spec:       spec,
+		module:     m,
+		session:    session,
+		key:        key,
+		ciphertext: ciphertext,
+	}, nil
+}
+
+// encrypt seals the plaintext with AES-GCM and returns the nonce, which the token may choose itself, and the sealed plaintext
+func (store *pkcs11Store) encrypt(iv, plaintext []byte) ([]byte, []byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	params := p11.NewGCMParams(iv, nil, gcmTagSize*8)
+	defer params.Free()
+	if err := store.module.ctx.EncryptInit(store.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, store.key); err != nil {
+		return nil, nil, err
+	}
+	sealed, err := store.module.ctx.Encrypt(store.session, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params.IV(), sealed, nil
+}
+
+// decrypt opens the ciphertext sealed by encrypt, a wrong key or a modified ciphertext fails the tag check
+func (store *pkcs11Store) decrypt(iv, sealed []byte) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	params := p11.NewGCMParams(iv, nil, gcmTagSize*8)
+	defer params.Free()
+	if err := store.module.ctx.DecryptInit(store.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, store.key); err != nil {
+		return nil, err
+	}
+	return store.module.ctx.Decrypt(store.session, sealed)
+}
+
+func (store *pkcs11Store) Get(key string) (string, error) {
+	value, err := store.ciphertext.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(value, ciphertextPrefix) {
+		return "", fmt.Errorf("key %s is not encrypted by a pkcs11 token", key)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, ciphertextPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to base64-decode: %w", err)
+	}
+	if len(data) < gcmIVSize+gcmTagSize {
+		return "", fmt.Errorf("ciphertext of key %s is truncated", key)
+	}
+
+	plaintext, err := store.decrypt(data[:gcmIVSize], data[gcmIVSize:])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt key %s: %w", key, err)
+	}
+	return string(plaintext), nil
+}
+
+func (store *pkcs11Store) Set(key, value string) error {
+	iv := make([]byte, gcmIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return err
+	}
+
+	iv, sealed, err := store.encrypt(iv, []byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key %s: %w", key, err)
+	}
+	if len(iv) != gcmIVSize {
+		return fmt.Errorf("token used a nonce of %d bytes to encrypt key %s, want %d", len(iv), key, gcmIVSize)
+	}
+
+	return store.ciphertext.Set(key, ciphertextPrefix+base64.StdEncoding.EncodeToString(append(iv, sealed...)))
+}
+
+func (store *pkcs11Store) List(prefix string) ([]string, error) {
+	return store.ciphertext.List(prefix)
+}
+
+func (store *pkcs11Store) Delete(key string) error {
+	return store.ciphertext.Delete(key)
+}
+
+func (store *pkcs11Store) Exists(key string) (bool, error) {
+	return store.ciphertext.Exists(key)
+}
+
+// Close closes the session of the token and unloads the module once no store uses it
+func (store *pkcs11Store) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	err := store.module.ctx.CloseSession(store.session)
+	if rerr := store.module.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+//go:build cgo && pkcs11
+
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	p11 "github.com/miekg/pkcs11"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+const (
+	testPIN      = "1234"
+	testKeyLabel = "stash-vault-test"
+)
+
+var slotPattern = regexp.MustCompile(`reassigned to slot (\d+)`)
+
+// softHSM initializes a token of SoftHSM in a temporary directory and returns the module path & slot of the token.
+// The module is found through SOFTHSM2_MODULE or the paths of the distribution packages.
+func softHSM(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util is required")
+	}
+	modulePath := os.Getenv("SOFTHSM2_MODULE")
+	if modulePath == "" {
+		for _, p := range []string{
+			"/usr/lib/softhsm/libsofthsm2.so",
+			"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+			"/usr/lib64/pkcs11/libsofthsm2.so",
+			"/usr/local/lib/softhsm/libsofthsm2.so",
+		} {
+			if _, err := os.Stat(p); err == nil {
+				modulePath = p
+				break
+			}
+		}
+	}
+	if modulePath == "" {
+		t.Skip("SoftHSM module not found, set SOFTHSM2_MODULE")
+	}
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\n", dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "stash-vault", "--pin", testPIN, "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to initialize token: %v: %s", err, out)
+	}
+	m := slotPattern.FindSubmatch(out)
+	if m == nil {
+		t.Fatalf("slot of the token not found in %s", out)
+	}
+	return modulePath, string(m[1])
+}
+
+func newTestStore(t *testing.T, modulePath, slot, pin, keyLabel string) (*pkcs11Store, error) {
+	t.Helper()
+	kc := fake.NewSimpleClientset(&core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hsm", Namespace: "demo"},
+		Data: map[string][]byte{
+			ModulePathKey: []byte(modulePath),
+			SlotKey:       []byte(slot + "\n"),
+			PINKey:        []byte(pin),
+		},
+	})
+	appBinding := &appcatalog.AppBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"},
+	}
+
+	st, err := New(kc, appBinding, &PKCS11Spec{
+		CredentialSecretRef: &core.LocalObjectReference{Name: "hsm"},
+		KeyLabel:            keyLabel,
+		SecretName:          "vault-keys",
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		if err := st.(*pkcs11Store).Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return st.(*pkcs11Store), nil
+}
+
+// generateTestKey creates a non-extractable AES-256 key in the token. The keys are provisioned by the operators of the
+// HSM, so the store never creates one.
+func generateTestKey(t *testing.T, modulePath, slot string) {
+	t.Helper()
+	m, err := loadModule(modulePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.release()
+
+	var slotID uint
+	if _, err := fmt.Sscan(slot, &slotID); err != nil {
+		t.Fatal(err)
+	}
+	session, err := m.openSession(slotID, testPIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.ctx.CloseSession(session)
+
+	_, err = m.ctx.GenerateKey(session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_KEY_GEN, nil)}, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_PRIVATE, true),
+		p11.NewAttribute(p11.CKA_SENSITIVE, true),
+		p11.NewAttribute(p11.CKA_EXTRACTABLE, false),
+		p11.NewAttribute(p11.CKA_ENCRYPT, true),
+		p11.NewAttribute(p11.CKA_DECRYPT, true),
+		p11.NewAttribute(p11.CKA_VALUE_LEN, 32),
+		p11.NewAttribute(p11.CKA_LABEL, testKeyLabel),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPKCS11Store(t *testing.T) {
+	modulePath, slot := softHSM(t)
+	generateTestKey(t, modulePath, slot)
+
+	st, err := newTestStore(t, modulePath, slot, testPIN, testKeyLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{
+		"k8s.a-root-token":   "s.root",
+		"k8s.a-unseal-key-0": "key-0",
+		"k8s.a-unseal-key-1": "key-1",
+		"k8s.b-unseal-key-0": "other",
+		"empty":              "",
+	}
+	for key, value := range values {
+		if err := st.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key, value := range values {
+		got, err := st.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != value {
+			t.Errorf("Get(%q) = %q, want %q", key, got, value)
+		}
+
+		// only the ciphertext is kept in the secret
+		ciphertext, err := st.ciphertext.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(ciphertext, ciphertextPrefix) || (value != "" && strings.Contains(ciphertext, value)) {
+			t.Errorf("secret keeps %q for key %s, want the ciphertext", ciphertext, key)
+		}
+	}
+
+	keys, err := st.List("k8s.a-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"k8s.a-root-token", "k8s.a-unseal-key-0", "k8s.a-unseal-key-1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %q, want %q", keys, want)
+	}
+
+	if err := st.Delete("k8s.a-unseal-key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := st.Exists("k8s.a-unseal-key-1"); err != nil || exists {
+		t.Errorf("Exists() after Delete() = %v, %v, want false", exists, err)
+	}
+}
+
+func TestPKCS11StoreCiphertextErrors(t *testing.T) {
+	modulePath, slot := softHSM(t)
+	generateTestKey(t, modulePath, slot)
+
+	st, err := newTestStore(t, modulePath, slot, testPIN, testKeyLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Set("k8s.a-root-token", "s.root"); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := st.ciphertext.Get("k8s.a-root-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a bit of the sealed key, after the nonce
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, ciphertextPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[gcmIVSize] ^= 1
+	tampered := ciphertextPrefix + base64.StdEncoding.EncodeToString(data)
+
+	cases := []struct {
+		name       string
+		ciphertext string
+	}{
+		{name: "tampered", ciphertext: tampered},
+		{name: "truncated", ciphertext: ciphertextPrefix + "AAAA"},
+		{name: "not base64", ciphertext: ciphertextPrefix + "!"},
+		{name: "not encrypted by the token", ciphertext: "s.root"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := st.ciphertext.Set("k8s.a-root-token", c.ciphertext); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.Get("k8s.a-root-token"); err == nil {
+				t.Error("Get() succeeded")
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	modulePath, slot := softHSM(t)
+	generateTestKey(t, modulePath, slot)
+
+	cases := []struct {
+		name       string
+		modulePath string
+		slot       string
+		pin        string
+		keyLabel   string
+	}{
+		{name: "wrong pin", modulePath: modulePath, slot: slot, pin: "0000", keyLabel: testKeyLabel},
+		{name: "missing key", modulePath: modulePath, slot: slot, pin: testPIN, keyLabel: "missing"},
+		{name: "invalid slot", modulePath: modulePath, slot: "slot-0", pin: testPIN, keyLabel: testKeyLabel},
+		{name: "missing module", modulePath: "/nonexistent/libpkcs11.so", slot: slot, pin: testPIN, keyLabel: testKeyLabel},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := newTestStore(t, c.modulePath, c.slot, c.pin, c.keyLabel); err == nil {
+				t.Error("New() succeeded")
+			}
+		})
+	}
+}
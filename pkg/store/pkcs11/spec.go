@@ -0,0 +1,99 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pkcs11 keeps the unseal keys & root token encrypted with an AES key held in a PKCS#11 token (HSM).
+// The ciphertext is kept in a Kubernetes secret.
+//
+// The vendor module of the token is a C shared library loaded through github.com/miekg/pkcs11, so the store is built
+// only with cgo and the pkcs11 build tag (make build-pkcs11):
+//
+//	CGO_ENABLED=1 go build -tags pkcs11 ./...
+//
+// Without the tag, the mode is recognized but fails to create the store.
+package pkcs11
+
+import (
+	"fmt"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+const (
+	ModePKCS11 = "pkcs11"
+
+	// keys of the credential secret
+	ModulePathKey = "module-path"
+	SlotKey       = "slot"
+	PINKey        = "pin"
+)
+
+func init() {
+	store.RegisterParametersMode(ModePKCS11, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		spec, err := FromAppBinding(appBinding)
+		if err != nil {
+			return nil, err
+		}
+		return New(kc, appBinding, spec)
+	})
+}
+
+// PKCS11Spec configures the unseal keys & root token to be encrypted with an AES key of a PKCS#11 token
+type PKCS11Spec struct {
+	// CredentialSecretRef is the secret holding the path of the PKCS#11 module, the slot of the token and the user PIN,
+	// in the module-path, slot & pin keys
+	CredentialSecretRef *core.LocalObjectReference `json:"credentialSecretRef"`
+	// KeyLabel is the label of the AES key in the token
+	KeyLabel string `json:"keyLabel"`
+	// SecretName is the Kubernetes secret the ciphertext is kept in
+	SecretName string `json:"secretName"`
+}
+
+// FromAppBinding returns the PKCS#11 spec of the unsealer mode in the app binding parameters, nil if the mode is not PKCS#11
+func FromAppBinding(appBinding *appcatalog.AppBinding) (*PKCS11Spec, error) {
+	spec := &PKCS11Spec{}
+	if ok, err := store.DecodeModeParameters(appBinding, ModePKCS11, spec); !ok || err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func validateSpec(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, spec *PKCS11Spec) error {
+	if appBinding == nil {
+		return fmt.Errorf("appBinding is nil")
+	}
+
+	if spec == nil {
+		return fmt.Errorf("pkcs11Spec is nil")
+	}
+
+	if kc == nil {
+		return fmt.Errorf("kubeClient is nil")
+	}
+
+	if spec.CredentialSecretRef == nil {
+		return fmt.Errorf("credentialSecretRef is nil")
+	}
+
+	if spec.KeyLabel == "" || spec.SecretName == "" {
+		return fmt.Errorf("keyLabel & secretName must be specified")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+import (
+	"reflect"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+func TestFromAppBinding(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		want    *PKCS11Spec
+		wantErr bool
+	}{
+		{
+			name: "pkcs11 mode",
+			raw:  `{"unsealer":{"mode":{"pkcs11":{"credentialSecretRef":{"name":"hsm"},"keyLabel":"vault","secretName":"vault-keys"}}}}`,
+			want: &PKCS11Spec{
+				CredentialSecretRef: &core.LocalObjectReference{Name: "hsm"},
+				KeyLabel:            "vault",
+				SecretName:          "vault-keys",
+			},
+		},
+		{
+			name: "other mode",
+			raw:  `{"unsealer":{"mode":{"kubernetesSecret":{"secretName":"vault-keys"}}}}`,
+		},
+		{
+			name: "no unsealer",
+			raw:  `{}`,
+		},
+		{
+			name:    "invalid parameters",
+			raw:     `{"unsealer":`,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			appBinding := &appcatalog.AppBinding{}
+			appBinding.Spec.Parameters = &runtime.RawExtension{Raw: []byte(c.raw)}
+
+			got, err := FromAppBinding(appBinding)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("FromAppBinding() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("FromAppBinding() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	valid := PKCS11Spec{
+		CredentialSecretRef: &core.LocalObjectReference{Name: "hsm"},
+		KeyLabel:            "vault",
+		SecretName:          "vault-keys",
+	}
+	cases := []struct {
+		name    string
+		modify  func(spec *PKCS11Spec)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(spec *PKCS11Spec) {}},
+		{name: "no credential secret", modify: func(spec *PKCS11Spec) { spec.CredentialSecretRef = nil }, wantErr: true},
+		{name: "no key label", modify: func(spec *PKCS11Spec) { spec.KeyLabel = "" }, wantErr: true},
+		{name: "no ciphertext secret", modify: func(spec *PKCS11Spec) { spec.SecretName = "" }, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spec := valid
+			c.modify(&spec)
+			err := validateSpec(fake.NewSimpleClientset(), &appcatalog.AppBinding{}, &spec)
+			if (err != nil) != c.wantErr {
+				t.Errorf("validateSpec() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !cgo || !pkcs11
+
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+import (
+	"fmt"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	"k8s.io/client-go/kubernetes"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// New fails, as the binary is built without PKCS#11 support
+func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, spec *PKCS11Spec) (store.StoreInterface, error) {
+	if err := validateSpec(kc, appBinding, spec); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unseal mode %s requires a binary built with cgo and the pkcs11 build tag", ModePKCS11)
+}
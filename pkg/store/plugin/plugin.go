@@ -0,0 +1,218 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin keeps the unseal keys & root token in an external store through an exec'd plugin binary.
+//
+// For every operation the plugin is run once with a JSON request on stdin and must write a JSON response on stdout:
+//
+//	request:  {"operation": "get|set|list|delete|exists", "key": "...", "value": "...", "prefix": "...", "namespace": "...", "appBinding": "..."}
+//	response: {"value": "...", "keys": ["..."], "exists": true, "notFound": true, "error": "..."}
+//
+// get returns value, or notFound if the key does not exist. set stores value under key. list returns the keys starting with prefix.
+// delete removes the key, a missing key is not an error. exists reports whether the key exists. A non-empty error fails the operation.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"stash.appscode.dev/vault/pkg/store"
+
+	shell "gomodules.xyz/go-sh"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+const (
+	ModeExternalPlugin = "externalPlugin"
+
+	OperationGet    = "get"
+	OperationSet    = "set"
+	OperationList   = "list"
+	OperationDelete = "delete"
+	OperationExists = "exists"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+func init() {
+	store.RegisterParametersMode(ModeExternalPlugin, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		spec, err := FromAppBinding(appBinding)
+		if err != nil {
+			return nil, err
+		}
+		return New(kc, appBinding, spec)
+	})
+}
+
+// PluginSpec configures the plugin binary holding the unseal keys & root token
+type PluginSpec struct {
+	// Command is the path of the plugin binary
+	Command string `json:"command"`
+	// Args are the arguments of the plugin binary
+	Args []string `json:"args,omitempty"`
+	// CredentialSecretRef is the secret whose data is passed to the plugin as environment variables
+	CredentialSecretRef *core.LocalObjectReference `json:"credentialSecretRef,omitempty"`
+	// TimeoutSeconds limits each run of the plugin, defaults to 30 seconds
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// FromAppBinding returns the plugin spec of the unsealer mode in the app binding parameters, nil if the mode is not externalPlugin
+func FromAppBinding(appBinding *appcatalog.AppBinding) (*PluginSpec, error) {
+	spec := &PluginSpec{}
+	if ok, err := store.DecodeModeParameters(appBinding, ModeExternalPlugin, spec); !ok || err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+type request struct {
+	Operation  string `json:"operation"`
+	Key        string `json:"key,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+	Namespace  string `json:"namespace"`
+	AppBinding string `json:"appBinding"`
+}
+
+type response struct {
+	Value    string   `json:"value,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+	Exists   bool     `json:"exists,omitempty"`
+	NotFound bool     `json:"notFound,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type pluginStore struct {
+	pluginSpec *PluginSpec
+	env        map[string]string
+	appBinding *appcatalog.AppBinding
+}
+
+func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, pluginSpec *PluginSpec) (*pluginStore, error) {
+	if appBinding == nil {
+		return nil, fmt.Errorf("appBinding is nil")
+	}
+
+	if pluginSpec == nil || pluginSpec.Command == "" {
+		return nil, fmt.Errorf("plugin command is empty")
+	}
+
+	env := map[string]string{}
+	if pluginSpec.CredentialSecretRef != nil {
+		if kc == nil {
+			return nil, fmt.Errorf("kubeClient is nil")
+		}
+		secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), pluginSpec.CredentialSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range secret.Data {
+			env[k] = string(v)
+		}
+	}
+
+	return &pluginStore{
+		pluginSpec: pluginSpec,
+		env:        env,
+		appBinding: appBinding,
+	}, nil
+}
+
+// call runs the plugin once for the request
+func (store *pluginStore) call(req request) (*response, error) {
+	req.Namespace = store.appBinding.Namespace
+	req.AppBinding = store.appBinding.Name
+
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := DefaultTimeout
+	if store.pluginSpec.TimeoutSeconds > 0 {
+		timeout = time.Duration(store.pluginSpec.TimeoutSeconds) * time.Second
+	}
+
+	sh := shell.NewSession()
+	sh.ShowCMD = false
+	for k, v := range store.env {
+		sh.SetEnv(k, v)
+	}
+	args := make([]interface{}, 0, len(store.pluginSpec.Args))
+	for _, arg := range store.pluginSpec.Args {
+		args = append(args, arg)
+	}
+
+	out, err := sh.SetInput(string(in)).SetTimeout(timeout).Command(store.pluginSpec.Command, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed to %s: %w", store.pluginSpec.Command, req.Operation, err)
+	}
+
+	resp := &response{}
+	if err := json.Unmarshal(out, resp); err != nil {
+		return nil, fmt.Errorf("plugin %s returned an invalid response to %s: %w", store.pluginSpec.Command, req.Operation, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s failed to %s: %s", store.pluginSpec.Command, req.Operation, resp.Error)
+	}
+	return resp, nil
+}
+
+func (store *pluginStore) Get(key string) (string, error) {
+	resp, err := store.call(request{Operation: OperationGet, Key: key})
+	if err != nil {
+		return "", err
+	}
+	if resp.NotFound {
+		return "", fmt.Errorf("%s not found in plugin %s", key, store.pluginSpec.Command)
+	}
+
+	return resp.Value, nil
+}
+
+func (store *pluginStore) Set(key, value string) error {
+	_, err := store.call(request{Operation: OperationSet, Key: key, Value: value})
+	return err
+}
+
+func (store *pluginStore) List(prefix string) ([]string, error) {
+	resp, err := store.call(request{Operation: OperationList, Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Keys, nil
+}
+
+func (store *pluginStore) Delete(key string) error {
+	_, err := store.call(request{Operation: OperationDelete, Key: key})
+	return err
+}
+
+func (store *pluginStore) Exists(key string) (bool, error) {
+	resp, err := store.call(request{Operation: OperationExists, Key: key})
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Exists, nil
+}
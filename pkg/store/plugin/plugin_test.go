@@ -0,0 +1,189 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// testPlugin keeps the keys as files of STORE_DIR, the keys starting with fail, crash & garbage
+// make it return an error, exit with a failure & write an invalid response respectively
+const testPlugin = `#!/bin/sh
+in=$(cat)
+field() {
+	printf '%s' "$in" | sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"
+}
+op=$(field operation)
+key=$(field key)
+case "$key" in
+fail*) echo '{"error":"access denied"}'; exit 0 ;;
+crash*) exit 3 ;;
+garbage*) echo 'not json'; exit 0 ;;
+esac
+case "$op" in
+get)
+	if [ -f "$STORE_DIR/$key" ]; then printf '{"value":"%s"}' "$(cat "$STORE_DIR/$key")"; else echo '{"notFound":true}'; fi ;;
+set)
+	printf '%s' "$(field value)" > "$STORE_DIR/$key" && echo '{}' ;;
+delete)
+	rm -f "$STORE_DIR/$key" && echo '{}' ;;
+exists)
+	if [ -f "$STORE_DIR/$key" ]; then echo '{"exists":true}'; else echo '{}'; fi ;;
+list)
+	keys=""
+	for f in "$STORE_DIR/$(field prefix)"*; do
+		[ -f "$f" ] && keys="$keys${keys:+,}\"$(basename "$f")\""
+	done
+	printf '{"keys":[%s]}' "$keys" ;;
+*)
+	echo '{"error":"unknown operation"}' ;;
+esac
+`
+
+func newTestStore(t *testing.T) *pluginStore {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+
+	dir := t.TempDir()
+	command := filepath.Join(dir, "plugin.sh")
+	if err := os.WriteFile(command, []byte(testPlugin), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	storeDir := filepath.Join(dir, "keys")
+	if err := os.Mkdir(storeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data of the credential secret is passed to the plugin as environment variables
+	kc := fake.NewSimpleClientset(&core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "plugin-credentials", Namespace: "demo"},
+		Data:       map[string][]byte{"STORE_DIR": []byte(storeDir)},
+	})
+	appBinding := &appcatalog.AppBinding{ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"}}
+	st, err := New(kc, appBinding, &PluginSpec{
+		Command:             command,
+		CredentialSecretRef: &core.LocalObjectReference{Name: "plugin-credentials"},
+		TimeoutSeconds:      10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestPluginStore(t *testing.T) {
+	st := newTestStore(t)
+
+	for key, value := range map[string]string{"vault-unseal-key-0": "a2V5LTA=", "vault-unseal-key-1": "a2V5LTE=", "vault-root-token": "aHZzLnRva2Vu"} {
+		if err := st.Set(key, value); err != nil {
+			t.Fatalf("Set(%s) failed: %v", key, err)
+		}
+	}
+
+	value, err := st.Get("vault-unseal-key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "a2V5LTE=" {
+		t.Errorf("expected a2V5LTE=, got %s", value)
+	}
+
+	keys, err := st.List("vault-unseal-key-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"vault-unseal-key-0", "vault-unseal-key-1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+
+	if exists, err := st.Exists("vault-root-token"); err != nil || !exists {
+		t.Errorf("expected vault-root-token to exist, got %v, %v", exists, err)
+	}
+	if err := st.Delete("vault-root-token"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := st.Exists("vault-root-token"); err != nil || exists {
+		t.Errorf("expected vault-root-token to be deleted, got %v, %v", exists, err)
+	}
+
+	// the notFound response fails Get
+	if _, err := st.Get("vault-root-token"); err == nil || !strings.Contains(err.Error(), "vault-root-token not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestPluginStoreErrors(t *testing.T) {
+	st := newTestStore(t)
+
+	cases := []struct {
+		name    string
+		run     func() error
+		wantErr string
+	}{
+		{
+			name: "error response",
+			run: func() error {
+				_, err := st.Get("fail-key")
+				return err
+			},
+			wantErr: "failed to get: access denied",
+		},
+		{
+			name: "error response of set",
+			run: func() error {
+				return st.Set("fail-key", "value")
+			},
+			wantErr: "failed to set: access denied",
+		},
+		{
+			name: "failed plugin",
+			run: func() error {
+				_, err := st.Exists("crash-key")
+				return err
+			},
+			wantErr: "failed to exists",
+		},
+		{
+			name: "invalid response",
+			run: func() error {
+				return st.Delete("garbage-key")
+			},
+			wantErr: "returned an invalid response to delete",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.run()
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("expected error containing %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
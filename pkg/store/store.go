@@ -18,17 +18,90 @@ package store
 
 import (
 	"fmt"
-
-	"stash.appscode.dev/vault/pkg/store/aws"
-	"stash.appscode.dev/vault/pkg/store/azure"
-	"stash.appscode.dev/vault/pkg/store/gcs"
-	"stash.appscode.dev/vault/pkg/store/k8s"
+	"sort"
+	"strings"
+	"sync"
 
 	"k8s.io/client-go/kubernetes"
 	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
 )
 
+// Factory creates the store of the unseal keys & root token of the VaultServer
+type Factory func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (StoreInterface, error)
+
+// Predicate reports whether the unsealer spec selects the store of a factory
+type Predicate func(appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) bool
+
+type registration struct {
+	mode      string
+	predicate Predicate
+	factory   Factory
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     []registration
+)
+
+// Register registers the store of an unseal mode, it is meant to be called from the init function of the store package.
+// The predicates of the registered stores must not overlap, an unsealer spec matched by more than one of them is rejected.
+func Register(mode string, predicate Predicate, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	for _, r := range registry {
+		if r.mode == mode {
+			panic(fmt.Sprintf("store of unseal mode %s is already registered", mode))
+		}
+	}
+	registry = append(registry, registration{
+		mode:      mode,
+		predicate: predicate,
+		factory:   factory,
+	})
+}
+
+// lookup returns the registered store selected by the unsealer spec, the registration order does not matter
+func lookup(appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (registration, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	var matches []registration
+	for _, r := range registry {
+		if r.predicate(appBinding, unsealerSpec) {
+			matches = append(matches, r)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return registration{}, fmt.Errorf("unknown unseal mode")
+	case 1:
+		return matches[0], nil
+	}
+	modes := make([]string, 0, len(matches))
+	for _, r := range matches {
+		modes = append(modes, r.mode)
+	}
+	sort.Strings(modes)
+	return registration{}, fmt.Errorf("more than one unseal mode is specified: %s", strings.Join(modes, ", "))
+}
+
+// Mode returns the unseal mode of the registered store selected by the unsealer spec,
+// empty if the spec does not select exactly one store
+func Mode(appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) string {
+	if unsealerSpec == nil {
+		return ""
+	}
+
+	r, err := lookup(appBinding, unsealerSpec)
+	if err != nil {
+		return ""
+	}
+	return r.mode
+}
+
 func NewStore(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unsealerSpec *vaultapi.UnsealerSpec) (StoreInterface, error) {
 	if appBinding == nil {
 		return nil, fmt.Errorf("appBinding is nil")
@@ -42,18 +115,9 @@ func NewStore(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, unseal
 		return nil, fmt.Errorf("kubeclient is nil")
 	}
 
-	mode := unsealerSpec.Mode
-	switch true {
-
-	case mode.GoogleKmsGcs != nil:
-		return gcs.New(kc, appBinding, mode.GoogleKmsGcs)
-	case mode.AwsKmsSsm != nil:
-		return aws.New(kc, appBinding, mode.AwsKmsSsm)
-	case mode.AzureKeyVault != nil:
-		return azure.New(kc, appBinding, mode.AzureKeyVault)
-	case mode.KubernetesSecret != nil:
-		return k8s.New(kc, appBinding, mode.KubernetesSecret)
+	r, err := lookup(appBinding, unsealerSpec)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, fmt.Errorf("unknown unseal mode")
+	return r.factory(kc, appBinding, unsealerSpec)
 }
@@ -0,0 +1,76 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+func TestLookup(t *testing.T) {
+	// the test modes are selected by the words of the app binding name
+	for _, mode := range []string{"test-a", "test-b"} {
+		mode := mode
+		Register(mode, func(appBinding *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) bool {
+			return strings.Contains(appBinding.Name, mode)
+		}, func(_ kubernetes.Interface, _ *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) (StoreInterface, error) {
+			return newMemStore(map[string]string{"mode": mode}), nil
+		})
+	}
+
+	cases := []struct {
+		name     string
+		wantMode string
+		wantErr  string
+	}{
+		{name: "vault-test-a", wantMode: "test-a"},
+		{name: "vault-test-b", wantMode: "test-b"},
+		{name: "vault", wantErr: "unknown unseal mode"},
+		{name: "vault-test-a-test-b", wantErr: "more than one unseal mode is specified: test-a, test-b"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			appBinding := &appcatalog.AppBinding{ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: "demo"}}
+			unsealer := &vaultapi.UnsealerSpec{}
+
+			if mode := Mode(appBinding, unsealer); mode != c.wantMode {
+				t.Errorf("expected mode %q, got %q", c.wantMode, mode)
+			}
+
+			st, err := NewStore(fake.NewSimpleClientset(), appBinding, unsealer)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Fatalf("expected error %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := st.Get("mode"); got != c.wantMode {
+				t.Errorf("expected the store of %q, got %q", c.wantMode, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transit
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// kvStore keeps each ciphertext in the value field of a KV secret named after the key
+type kvStore struct {
+	client    *api.Client
+	mountPath string
+	path      string
+	version   int
+}
+
+func newKVStore(client *api.Client, transitSpec *VaultTransitSpec) *kvStore {
+	store := &kvStore{
+		client:    client,
+		mountPath: strings.Trim(transitSpec.KVMountPath, "/"),
+		path:      strings.Trim(transitSpec.KVPath, "/"),
+		version:   transitSpec.KVVersion,
+	}
+	if store.mountPath == "" {
+		store.mountPath = DefaultKVMountPath
+	}
+	if store.version == 0 {
+		store.version = 2
+	}
+	return store
+}
+
+func (store *kvStore) dataPath(key string) string {
+	if store.version == 1 {
+		return path.Join(store.mountPath, store.path, key)
+	}
+	return path.Join(store.mountPath, "data", store.path, key)
+}
+
+func (store *kvStore) metadataPath(key string) string {
+	if store.version == 1 {
+		return path.Join(store.mountPath, store.path, key)
+	}
+	return path.Join(store.mountPath, "metadata", store.path, key)
+}
+
+// read returns the value of the key, or false if the key does not exist
+func (store *kvStore) read(key string) (string, bool, error) {
+	secret, err := store.client.Logical().Read(store.dataPath(key))
+	if err != nil {
+		return "", false, err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", false, nil
+	}
+
+	data := secret.Data
+	if store.version != 1 {
+		// the data of a deleted version is null
+		data, _ = secret.Data["data"].(map[string]interface{})
+	}
+	value, ok := data["value"].(string)
+	return value, ok, nil
+}
+
+func (store *kvStore) Get(key string) (string, error) {
+	value, ok, err := store.read(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s not found in %s", key, store.dataPath(key))
+	}
+	return value, nil
+}
+
+func (store *kvStore) Set(key, value string) error {
+	data := map[string]interface{}{
+		"value": value,
+	}
+	if store.version != 1 {
+		data = map[string]interface{}{
+			"data": data,
+		}
+	}
+
+	_, err := store.client.Logical().Write(store.dataPath(key), data)
+	return err
+}
+
+func (store *kvStore) List(prefix string) ([]string, error) {
+	secret, err := store.client.Logical().List(store.metadataPath(""))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	names, _ := secret.Data["keys"].([]interface{})
+	var keys []string
+	for _, name := range names {
+		key, ok := name.(string)
+		// skip the sub paths
+		if !ok || strings.HasSuffix(key, "/") {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete deletes every version of the key
+func (store *kvStore) Delete(key string) error {
+	_, err := store.client.Logical().Delete(store.metadataPath(key))
+	return err
+}
+
+func (store *kvStore) Exists(key string) (bool, error) {
+	_, ok, err := store.read(key)
+	return ok, err
+}
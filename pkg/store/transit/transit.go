@@ -0,0 +1,226 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/vault/pkg/store"
+	"stash.appscode.dev/vault/pkg/store/k8s"
+
+	"github.com/hashicorp/vault/api"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	vaultapi "kubevault.dev/apimachinery/apis/kubevault/v1alpha2"
+)
+
+const (
+	ModeVaultTransit = "vaultTransit"
+
+	DefaultTransitMountPath = "transit"
+	DefaultKVMountPath      = "secret"
+
+	TokenKey  = "token"
+	CACertKey = "ca.crt"
+)
+
+func init() {
+	store.RegisterParametersMode(ModeVaultTransit, func(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, _ *vaultapi.UnsealerSpec) (store.StoreInterface, error) {
+		spec, err := FromAppBinding(appBinding)
+		if err != nil {
+			return nil, err
+		}
+		return New(kc, appBinding, spec)
+	})
+}
+
+// VaultTransitSpec configures the unseal keys & root token to be encrypted with a Transit key of another Vault.
+// The ciphertext is kept in the Kubernetes secret SecretName, or in the KV path KVPath of the same Vault.
+type VaultTransitSpec struct {
+	// Address of the Vault holding the Transit key
+	Address string `json:"address"`
+	// Namespace of the Vault holding the Transit key (Vault Enterprise)
+	Namespace string `json:"namespace,omitempty"`
+	// MountPath of the Transit secrets engine, defaults to transit
+	MountPath string `json:"mountPath,omitempty"`
+	// KeyName is the name of the Transit key
+	KeyName string `json:"keyName"`
+	// TokenSecretRef is the secret holding the Vault token with access to the Transit key, in the token key
+	TokenSecretRef *core.LocalObjectReference `json:"tokenSecretRef"`
+	// TLSSecretRef is the secret holding the CA certificate of the Vault, in the ca.crt key
+	TLSSecretRef *core.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+
+	// SecretName is the Kubernetes secret the ciphertext is kept in
+	SecretName string `json:"secretName,omitempty"`
+	// KVMountPath is the mount path of the KV secrets engine, defaults to secret
+	KVMountPath string `json:"kvMountPath,omitempty"`
+	// KVPath is the path under the KV secrets engine the ciphertext is kept in
+	KVPath string `json:"kvPath,omitempty"`
+	// KVVersion is the version of the KV secrets engine, defaults to 2
+	KVVersion int `json:"kvVersion,omitempty"`
+}
+
+// FromAppBinding returns the Transit spec of the unsealer mode in the app binding parameters, nil if the mode is not Transit
+func FromAppBinding(appBinding *appcatalog.AppBinding) (*VaultTransitSpec, error) {
+	spec := &VaultTransitSpec{}
+	if ok, err := store.DecodeModeParameters(appBinding, ModeVaultTransit, spec); !ok || err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+type transitStore struct {
+	transitSpec *VaultTransitSpec
+	client      *api.Client
+	// ciphertext keeps the ciphertext of the keys
+	ciphertext store.StoreInterface
+}
+
+func New(kc kubernetes.Interface, appBinding *appcatalog.AppBinding, transitSpec *VaultTransitSpec) (*transitStore, error) {
+	if appBinding == nil {
+		return nil, fmt.Errorf("appBinding is nil")
+	}
+
+	if transitSpec == nil {
+		return nil, fmt.Errorf("transitSpec is nil")
+	}
+
+	if kc == nil {
+		return nil, fmt.Errorf("kubeClient is nil")
+	}
+
+	if transitSpec.Address == "" || transitSpec.KeyName == "" {
+		return nil, fmt.Errorf("address & keyName of the transit key must be specified")
+	}
+
+	if transitSpec.TokenSecretRef == nil {
+		return nil, fmt.Errorf("tokenSecretRef is nil")
+	}
+
+	if (transitSpec.SecretName == "") == (transitSpec.KVPath == "") {
+		return nil, fmt.Errorf("exactly one of secretName & kvPath must be specified")
+	}
+
+	secret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), transitSpec.TokenSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := secret.Data[TokenKey]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in secret", TokenKey)
+	}
+
+	config := api.DefaultConfig()
+	config.Address = transitSpec.Address
+	if transitSpec.TLSSecretRef != nil {
+		tlsSecret, err := kc.CoreV1().Secrets(appBinding.Namespace).Get(context.TODO(), transitSpec.TLSSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if err := config.ConfigureTLS(&api.TLSConfig{CACertBytes: tlsSecret.Data[CACertKey]}); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(strings.TrimSpace(string(token)))
+	if transitSpec.Namespace != "" {
+		client.SetNamespace(transitSpec.Namespace)
+	}
+
+	var ciphertext store.StoreInterface
+	if transitSpec.SecretName != "" {
+		ciphertext, err = k8s.New(kc, appBinding, &vaultapi.KubernetesSecretSpec{SecretName: transitSpec.SecretName})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ciphertext = newKVStore(client, transitSpec)
+	}
+
+	return &transitStore{
+		transitSpec: transitSpec,
+		client:      client,
+		ciphertext:  ciphertext,
+	}, nil
+}
+
+func (store *transitStore) mountPath() string {
+	if store.transitSpec.MountPath == "" {
+		return DefaultTransitMountPath
+	}
+	return strings.Trim(store.transitSpec.MountPath, "/")
+}
+
+func (store *transitStore) Get(key string) (string, error) {
+	ciphertext, err := store.ciphertext.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := store.client.Logical().Write(fmt.Sprintf("%s/decrypt/%s", store.mountPath(), store.transitSpec.KeyName), map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to transit decrypt: %w", err)
+	}
+	if resp == nil || resp.Data["plaintext"] == nil {
+		return "", fmt.Errorf("failed to transit decrypt: empty response")
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(fmt.Sprint(resp.Data["plaintext"]))
+	if err != nil {
+		return "", fmt.Errorf("failed to base64-decode: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func (store *transitStore) Set(key, value string) error {
+	resp, err := store.client.Logical().Write(fmt.Sprintf("%s/encrypt/%s", store.mountPath(), store.transitSpec.KeyName), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString([]byte(value)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to transit encrypt: %w", err)
+	}
+	if resp == nil || resp.Data["ciphertext"] == nil {
+		return fmt.Errorf("failed to transit encrypt: empty response")
+	}
+
+	return store.ciphertext.Set(key, fmt.Sprint(resp.Data["ciphertext"]))
+}
+
+func (store *transitStore) List(prefix string) ([]string, error) {
+	return store.ciphertext.List(prefix)
+}
+
+func (store *transitStore) Delete(key string) error {
+	return store.ciphertext.Delete(key)
+}
+
+func (store *transitStore) Exists(key string) (bool, error) {
+	return store.ciphertext.Exists(key)
+}
@@ -0,0 +1,192 @@
+//go:build vault_integration
+
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The tests run against a dev-mode Vault, started with:
+//
+//	vault server -dev -dev-root-token-id=root
+//	VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=root go test -tags vault_integration ./pkg/store/transit/...
+//
+// The dev-mode Vault has a KV v2 engine mounted at secret, the transit engine is mounted by the tests.
+package transit
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+const testKeyName = "stash-vault-test"
+
+// devVault returns the address & token of the dev-mode Vault, with the transit engine and key in place
+func devVault(t *testing.T) (string, string) {
+	t.Helper()
+	addr, token := os.Getenv(api.EnvVaultAddress), os.Getenv(api.EnvVaultToken)
+	if addr == "" || token == "" {
+		t.Skipf("%s & %s of a dev-mode Vault are required", api.EnvVaultAddress, api.EnvVaultToken)
+	}
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mounts[DefaultTransitMountPath+"/"]; !ok {
+		if err := client.Sys().Mount(DefaultTransitMountPath, &api.MountInput{Type: "transit"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Logical().Write(DefaultTransitMountPath+"/keys/"+testKeyName, nil); err != nil {
+		t.Fatal(err)
+	}
+	return addr, token
+}
+
+func TestTransitStore(t *testing.T) {
+	addr, token := devVault(t)
+
+	cases := []struct {
+		name string
+		spec VaultTransitSpec
+	}{
+		{
+			name: "ciphertext in kubernetes secret",
+			spec: VaultTransitSpec{SecretName: "vault-keys"},
+		},
+		{
+			name: "ciphertext in kv v2",
+			spec: VaultTransitSpec{KVPath: "stash-vault-test/" + strings.ToLower(t.Name())},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kc := fake.NewSimpleClientset(&core.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "transit-token", Namespace: "demo"},
+				Data:       map[string][]byte{TokenKey: []byte(token + "\n")},
+			})
+			appBinding := &appcatalog.AppBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"},
+			}
+
+			spec := c.spec
+			spec.Address = addr
+			spec.KeyName = testKeyName
+			spec.TokenSecretRef = &core.LocalObjectReference{Name: "transit-token"}
+			st, err := New(kc, appBinding, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values := map[string]string{
+				"k8s.a-root-token":   "s.root",
+				"k8s.a-unseal-key-0": "key-0",
+				"k8s.a-unseal-key-1": "key-1",
+				"k8s.b-unseal-key-0": "other",
+			}
+			for key, value := range values {
+				if err := st.Set(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Cleanup(func() {
+				for key := range values {
+					_ = st.Delete(key)
+				}
+			})
+
+			for key, value := range values {
+				got, err := st.Get(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != value {
+					t.Errorf("Get(%q) = %q, want %q", key, got, value)
+				}
+				// the plaintext must not be kept
+				ciphertext, err := st.ciphertext.Get(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(ciphertext, "vault:v") {
+					t.Errorf("ciphertext of %s is %q, want a transit ciphertext", key, ciphertext)
+				}
+			}
+
+			keys, err := st.List("k8s.a-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"k8s.a-root-token", "k8s.a-unseal-key-0", "k8s.a-unseal-key-1"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List() = %q, want %q", keys, want)
+			}
+
+			if err := st.Delete("k8s.a-unseal-key-1"); err != nil {
+				t.Fatal(err)
+			}
+			if exists, err := st.Exists("k8s.a-unseal-key-1"); err != nil || exists {
+				t.Errorf("Exists() after Delete() = %v, %v, want false", exists, err)
+			}
+			if exists, err := st.Exists("k8s.a-unseal-key-0"); err != nil || !exists {
+				t.Errorf("Exists() = %v, %v, want true", exists, err)
+			}
+		})
+	}
+}
+
+func TestTransitStoreWrongKey(t *testing.T) {
+	addr, token := devVault(t)
+
+	kc := fake.NewSimpleClientset(&core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "transit-token", Namespace: "demo"},
+		Data:       map[string][]byte{TokenKey: []byte(token)},
+	})
+	appBinding := &appcatalog.AppBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "demo"},
+	}
+	st, err := New(kc, appBinding, &VaultTransitSpec{
+		Address:        addr,
+		KeyName:        testKeyName + "-missing",
+		TokenSecretRef: &core.LocalObjectReference{Name: "transit-token"},
+		SecretName:     "vault-keys",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the ciphertext of another transit key can't be decrypted
+	if err := st.ciphertext.Set("k8s.a-root-token", "vault:v1:AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get("k8s.a-root-token"); err == nil {
+		t.Error("Get() with a missing transit key succeeded")
+	}
+}
@@ -24,8 +24,11 @@ import (
 	"strings"
 	"time"
 
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	stash "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/vault/pkg/store"
+	_ "stash.appscode.dev/vault/pkg/store/all"
 
 	"github.com/hashicorp/vault/api"
 	shell "gomodules.xyz/go-sh"
@@ -38,6 +41,7 @@ import (
 	kmapi "kmodules.xyz/client-go/api/v1"
 	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 	appcatalog_cs "kmodules.xyz/custom-resources/client/clientset/versioned"
+	vaultconfig "kubevault.dev/apimachinery/apis/config/v1alpha1"
 )
 
 const (
@@ -60,6 +64,9 @@ type vaultOptions struct {
 	backupSessionName   string
 	appBindingName      string
 	appBindingNamespace string
+	appBindingNames     []string
+	appBindingSelector  string
+	maxConcurrency      int
 	vaultArgs           string
 	waitTimeout         int32
 	outputDir           string
@@ -73,14 +80,95 @@ type vaultOptions struct {
 	interimDataDir string
 
 	// vault related flags
-	force bool
+	force            bool
+	forceBackup      bool
+	unpackSnapshot   bool
+	removeStalePeers bool
+	keysOnly         bool
+
+	// point-in-time restore selection
+	restoreBefore   string
+	raftIndexBefore uint64
+
+	// offline DR bundle to restore from
+	bundleFile           string
+	bundlePassphraseFile string
 
 	keyPrefix    string
 	oldKeyPrefix string
+	// use the prefix of the only root token in the store if the root token of keyPrefix is not found
+	detectKeyPrefix bool
+	// unsealer modes the unseal keys & root token are mirrored into
+	secondaryStoreFiles []string
+
+	// standalone mode, without Kubernetes & AppBinding
+	standalone           bool
+	standaloneConfigFile string
+	standaloneConfig     standaloneConfig
+}
+
+// vaultTarget describes the VaultServer to backup or restore, and the store of its unseal keys & root token
+type vaultTarget struct {
+	// appBinding is nil in standalone mode
+	appBinding   *appcatalog.AppBinding
+	unsealMode   string
+	secretShares int
+	// overwriteExisting allows restore to overwrite the unseal keys & root token present in the store
+	overwriteExisting bool
+	newStore          func() (store.StoreInterface, error)
+}
+
+func (opt *vaultOptions) newAppBindingTarget(appBinding *appcatalog.AppBinding, params vaultconfig.VaultServerConfiguration) *vaultTarget {
+	target := &vaultTarget{
+		appBinding: appBinding,
+		unsealMode: unsealMode(params.Unsealer),
+		newStore: func() (store.StoreInterface, error) {
+			if params.Unsealer == nil {
+				return nil, fmt.Errorf("unsealer spec is nil")
+			}
+			st, err := store.NewStore(opt.kubeClient, appBinding, params.Unsealer)
+			if err != nil {
+				return nil, err
+			}
+			fanout, err := opt.withSecondaryStores(st, appBinding, params.Unsealer)
+			if err != nil {
+				closeStore(st)
+				return nil, err
+			}
+			return fanout, nil
+		},
+	}
+	if params.Unsealer != nil {
+		target.secretShares = int(params.Unsealer.SecretShares)
+		target.overwriteExisting = params.Unsealer.OverwriteExisting
+	}
+	if target.unsealMode == "" {
+		// the modes the stores read from the app binding parameters
+		target.unsealMode = store.Mode(appBinding, params.Unsealer)
+	}
+	return target
+}
+
+// name returns the namespace/name of the AppBinding of the target
+func (target *vaultTarget) name() string {
+	if target.appBinding == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", target.appBinding.Namespace, target.appBinding.Name)
+}
+
+// closeStore releases the clients of the store, failing to do so does not fail the operation
+func closeStore(st store.StoreInterface) {
+	if err := store.Close(st); err != nil {
+		klog.Warningf("failed to close store. Reason: %v", err)
+	}
 }
 
 const (
 	VaultStorageBackendRaft = "raft"
+
+	// HostBackupSkipped indicates that the backup was skipped because nothing has changed since the last backup
+	HostBackupSkipped api_v1beta1.HostBackupPhase = "Skipped"
 )
 
 type sessionWrapper struct {